@@ -0,0 +1,2159 @@
+package webanalyzer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/net/publicsuffix"
+)
+
+// analyzerHTML is the web UI template, embedded so the server runs from any directory.
+//
+//go:embed analyzer.html
+var analyzerHTML string
+
+var pageTmpl = template.Must(template.New("analyzer.html").Parse(analyzerHTML))
+
+// version identifies the build in every result. Release builds set it with
+// -ldflags "-X github.com/jestress/webanalyzer.version=v1.2.3".
+var version = "dev"
+
+// linkDial dials connections for link checks; tests swap it to simulate dial failures.
+var linkDial = (&net.Dialer{
+	Timeout:   4 * time.Second,
+	KeepAlive: 15 * time.Second,
+}).DialContext
+
+// errBodyTooLarge reports a page body over the size limit.
+var errBodyTooLarge = errors.New("response too large")
+
+// errNonOKStatus reports a page answering with a status outside 2xx/3xx.
+var errNonOKStatus = errors.New("non-OK status")
+
+// linkTimeout returns the timeout for a single link check: the override when set,
+// otherwise -per-request-timeout, and never more than the overall analysis budget.
+func (o analyzeOptions) linkTimeout() time.Duration {
+	s := o.settings()
+	if o.LinkTimeout <= 0 {
+		return s.PerRequestTimeout
+	}
+	return min(o.LinkTimeout, s.TotalBudget)
+}
+
+// headerTimeout returns how long link checks and title fetches wait for response headers:
+// -header-timeout, but never less than linkTimeout, so that raising the link timeout lets
+// slow servers answer.
+func (o analyzeOptions) headerTimeout() time.Duration {
+	return max(o.settings().HeaderTimeout, o.linkTimeout())
+}
+
+// fetchTimeout returns the timeout for the whole page fetch: the override when set, otherwise
+// the -fetch-timeout default.
+func (o analyzeOptions) fetchTimeout() time.Duration {
+	if o.FetchTimeout > 0 {
+		return o.FetchTimeout
+	}
+	return o.settings().FetchTimeout
+}
+
+// bodyLimit returns the most bytes of the page body fetch reads, both compressed and
+// decoded: the override when set, otherwise -max-body-size.
+func (o analyzeOptions) bodyLimit() int64 {
+	if o.BodyLimit > 0 {
+		return o.BodyLimit
+	}
+	return o.settings().MaxBodySize
+}
+
+// userAgent returns the User-Agent for outbound requests: the override when set, otherwise
+// the -user-agent default.
+func (o analyzeOptions) userAgent() string {
+	if o.UserAgent != "" {
+		return o.UserAgent
+	}
+	return o.settings().UserAgent
+}
+
+// maxLinks returns the cap on links checked per page.
+func (o analyzeOptions) maxLinks() int {
+	if o.MaxLinks > 0 {
+		return o.MaxLinks
+	}
+	return o.settings().MaxLinks
+}
+
+// workers returns the number of concurrent link/image checks.
+func (o analyzeOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return o.settings().LinkWorkers
+}
+
+// linkRetries returns how often a link check that failed transiently is retried: the
+// override when set (negative disables retries), otherwise linkCheckRetries.
+func (o analyzeOptions) linkRetries() int {
+	switch {
+	case o.LinkRetries < 0:
+		return 0
+	case o.LinkRetries > 0:
+		return o.LinkRetries
+	}
+	return linkCheckRetries
+}
+
+// redirectLimit returns the redirect hops fetch may follow: maxRedirects unless the request
+// asked for fewer.
+func (o analyzeOptions) redirectLimit() int {
+	if o.MaxRedirects > 0 {
+		return o.MaxRedirects
+	}
+	return maxRedirects
+}
+
+// capLists trims the open-ended list fields to limit items so huge pages can't produce
+// huge responses, recording how many were dropped in Omitted under the field's JSON name.
+// Capped link results keep the broken and robots-disallowed links first; capped internal
+// titles keep the first URLs in sorted order.
+func (r *Analysis) capLists(limit int) {
+	r.DownloadLinks = trimList(r, "downloadLinks", r.DownloadLinks, limit)
+	r.UniqueDomains = trimList(r, "uniqueDomains", r.UniqueDomains, limit)
+	r.Feeds = trimList(r, "feeds", r.Feeds, limit)
+	r.Sitemaps = trimList(r, "sitemaps", r.Sitemaps, limit)
+	r.Canonicals = trimList(r, "canonicals", r.Canonicals, limit)
+	r.MetaIssues = trimList(r, "metaIssues", r.MetaIssues, limit)
+	r.RedirectChain = trimList(r, "redirectChain", r.RedirectChain, limit)
+	r.RedirectSamples = trimList(r, "redirectSamples", r.RedirectSamples, limit)
+	if len(r.LinkResults) > limit {
+		flagged := func(l LinkResult) bool { return l.Broken || l.Disallowed }
+		slices.SortStableFunc(r.LinkResults, func(a, b LinkResult) int {
+			switch {
+			case flagged(a) && !flagged(b):
+				return -1
+			case flagged(b) && !flagged(a):
+				return 1
+			}
+			return 0
+		})
+		r.LinkResults = trimList(r, "linkResults", r.LinkResults, limit)
+		slices.SortFunc(r.LinkResults, func(a, b LinkResult) int { return strings.Compare(a.URL, b.URL) })
+	}
+	if len(r.InternalTitles) > limit {
+		dropped := slices.Sorted(maps.Keys(r.InternalTitles))[limit:]
+		for _, u := range dropped {
+			delete(r.InternalTitles, u)
+		}
+		r.omit("internalTitles", len(dropped))
+	}
+}
+
+// trimList returns the first limit items of list, recording any dropped ones in r.Omitted
+// under name.
+func trimList[T any](r *Analysis, name string, list []T, limit int) []T {
+	if len(list) <= limit {
+		return list
+	}
+	r.omit(name, len(list)-limit)
+	return list[:limit]
+}
+
+// omit records n entries dropped from the list field name.
+func (r *Analysis) omit(name string, n int) {
+	if r.Omitted == nil {
+		r.Omitted = make(map[string]int)
+	}
+	r.Omitted[name] = n
+}
+
+// setMixedContent reports the plain-http subresources as mixed content if page, the URL the
+// document was served from, is HTTPS, and clears the report otherwise. At most limit URLs
+// are listed.
+func (r *Analysis) setMixedContent(page *url.URL, limit int) {
+	r.MixedContent, r.MixedContentCount = nil, 0
+	delete(r.Omitted, "mixedContent")
+	if page.Scheme != "https" {
+		return
+	}
+	r.MixedContentCount = len(r.httpResources)
+	r.MixedContent = trimList(r, "mixedContent", r.httpResources, limit)
+}
+
+// ScreenshotURL returns the thumbnail as a data: URL the template may use as an image source.
+func (r *Analysis) ScreenshotURL() template.URL {
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(r.Screenshot))
+}
+
+// normalizeURL ensures the URL has a scheme and is valid.
+func normalizeURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// fetch retrieves the URL content with a timeout and returns the response and the decoded page.
+func fetch(ctx context.Context, u string, opts analyzeOptions) (*http.Response, *fetchedPage, error) {
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithAttributes(attribute.String("url.full", u)))
+	defer span.End()
+	defer func(start time.Time) { fetchDuration.Observe(time.Since(start).Seconds()) }(time.Now())
+
+	// time to first byte of the final hop: GetConn fires again for each redirect
+	var connStart time.Time
+	var ttfb time.Duration
+	trace := &httptrace.ClientTrace{
+		GetConn:              func(string) { connStart = time.Now() },
+		GotFirstResponseByte: func() { ttfb = time.Since(connStart) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Ask for gzip explicitly: net/http then leaves decoding to us, which lets
+	// readPage count the compressed bytes received on the wire.
+	req.Header.Set("Accept-Encoding", "gzip")
+	if opts.NoCache {
+		// ask CDNs and proxies for a fresh copy instead of a cached one
+		req.Header.Set("Cache-Control", "no-cache")
+		req.Header.Set("Pragma", "no-cache")
+	}
+
+	cfg := opts.settings()
+	redirects := &redirectLog{limit: opts.redirectLimit(), maxURL: cfg.MaxURLLength}
+	client := &http.Client{
+		Transport: newTransport(cfg, (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext, 20, 5*time.Second, cfg.HeaderTimeout, opts.userAgent()),
+		CheckRedirect: redirects.check,
+		Timeout:       opts.fetchTimeout(),
+	}
+
+	if cfg.CookieRefetch {
+		// some sites only serve real content once a cookie from the first visit is sent back
+		jar, _ := cookiejar.New(nil)
+		client.Jar = jar
+		if err := primeCookies(ctx, client, u); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return nil, nil, fmt.Errorf("cookie request failed: %w", err)
+		}
+		*redirects = redirectLog{limit: redirects.limit, maxURL: redirects.maxURL}
+	}
+
+	redirects.sent = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, nil, fmt.Errorf("request failed: %w", err)
+	}
+	redirects.finish(resp)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		// We still read body for HTML version/title if possible, but return error to satisfy the requirement.
+		page, _ := readPage(resp, opts.bodyLimit()) // an oversized error page is just cut off
+		if page != nil {
+			redirects.record(page)
+		}
+		return resp, page, fmt.Errorf("%w: %d %s", errNonOKStatus, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if opts.HeadersOnly {
+		page := &fetchedPage{
+			ContentEncoding: strings.ToLower(resp.Header.Get("Content-Encoding")),
+			TTFB:            ttfb,
+		}
+		redirects.record(page)
+		return resp, page, nil
+	}
+	// a capped redirect response often has no HTML body; analyze whatever it has
+	if ct := resp.Header.Get("Content-Type"); !redirects.capped && !isHTMLContentType(ct, cfg.htmlTypes) {
+		return resp, nil, fmt.Errorf("unsupported content type %q: not an HTML page", ct)
+	}
+	page, err := readPage(resp, opts.bodyLimit())
+	switch {
+	case errors.Is(err, errBodyTooLarge) && opts.PrefixOnly:
+		// the caller only wants the beginning of the body
+	case errors.Is(err, errBodyTooLarge):
+		return resp, nil, err
+	case err != nil:
+		return resp, nil, fmt.Errorf("failed reading response body: %w", err)
+	}
+	redirects.record(page)
+	page.TTFB = ttfb
+	return resp, page, nil
+}
+
+// followMetaRefresh fetches the target of a zero-delay <meta http-equiv="refresh"> on the
+// page fetched as resp, once: the target's own refresh is not followed. It returns the page
+// to analyze and the URL of the bounce page, or resp and page unchanged and "" when there is
+// no such refresh or it points back at the page itself.
+func followMetaRefresh(ctx context.Context, resp *http.Response, page *fetchedPage, opts analyzeOptions) (*http.Response, *fetchedPage, string, error) {
+	from := resp.Request.URL
+	body, _ := toUTF8(page.Body, resp.Header.Get("Content-Type"))
+	target := metaRefreshTarget(body, from)
+	if target == nil || sameDocumentURL(target.String(), from) {
+		return resp, page, "", nil
+	}
+	_ = resp.Body.Close()
+	resp, page, err := fetch(ctx, target.String(), opts)
+	if err != nil {
+		return resp, page, from.String(), fmt.Errorf("following meta refresh: %w", err)
+	}
+	return resp, page, from.String(), nil
+}
+
+// metaRefreshTarget returns the URL of the first <meta http-equiv="refresh"> in body,
+// resolved against base, when it redirects with no delay ("0;url=..."). It returns nil for
+// delayed refreshes, refreshes without a URL and non-http(s) targets.
+func metaRefreshTarget(body []byte, base *url.URL) *url.URL {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var content string
+	doc.Find("meta[http-equiv][content]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if equiv, _ := s.Attr("http-equiv"); strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			content, _ = s.Attr("content")
+			return false
+		}
+		return true
+	})
+	delay, rest, ok := strings.Cut(content, ";")
+	if !ok {
+		delay, rest, ok = strings.Cut(content, ",")
+	}
+	if d, err := strconv.ParseFloat(strings.TrimSpace(delay), 64); !ok || err != nil || d != 0 {
+		return nil
+	}
+	rest = strings.TrimSpace(rest)
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		if after, found := strings.CutPrefix(strings.TrimSpace(rest[3:]), "="); found {
+			rest = strings.TrimSpace(after)
+		}
+	}
+	rest = strings.Trim(rest, `"'`)
+	if rest == "" {
+		return nil
+	}
+	u, err := base.Parse(rest)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	return u
+}
+
+// redirectLog is fetch's redirect policy. It follows at most limit redirects, recording
+// each URL on the way with its status and latency, and refuses Location URLs longer than
+// maxURL bytes.
+type redirectLog struct {
+	limit   int
+	maxURL  int
+	chain   []RedirectHop
+	capped  bool
+	sent    time.Time     // when the latest hop's request was sent; fetch sets it for the first
+	elapsed time.Duration // spent on redirect hops before the final page
+}
+
+func (l *redirectLog) check(req *http.Request, via []*http.Request) error {
+	if n := len(req.URL.String()); n > l.maxURL {
+		return fmt.Errorf("redirect target is %d bytes long, over the %d-byte URL limit", n, l.maxURL)
+	}
+	if len(l.chain) == 0 {
+		l.chain = append(l.chain, RedirectHop{URL: via[0].URL.String()})
+	}
+	hop := &l.chain[len(l.chain)-1]
+	if req.Response != nil {
+		hop.Status = req.Response.StatusCode
+	}
+	took := time.Since(l.sent)
+	hop.DurationMs = took.Milliseconds()
+	if len(via) > l.limit {
+		// stop here and analyze the redirect response itself, which makes it the final page
+		l.capped = true
+		return http.ErrUseLastResponse
+	}
+	l.elapsed += took
+	l.sent = time.Now()
+	l.chain = append(l.chain, RedirectHop{URL: req.URL.String()})
+	return nil
+}
+
+// finish records the status and latency of the final response as those of the last hop.
+func (l *redirectLog) finish(resp *http.Response) {
+	if n := len(l.chain); n > 0 && !l.capped {
+		l.chain[n-1].Status = resp.StatusCode
+		l.chain[n-1].DurationMs = time.Since(l.sent).Milliseconds()
+	}
+}
+
+// record copies the redirect details into p and times the final page up to now.
+func (l *redirectLog) record(p *fetchedPage) {
+	p.RedirectChain, p.RedirectsCapped = l.chain, l.capped
+	p.RedirectTime, p.FinalFetch = l.elapsed, time.Since(l.sent)
+}
+
+// primeCookies performs a preliminary GET so the client's cookie jar collects any cookies
+// the site sets on a first visit. The response body is discarded.
+func primeCookies(ctx context.Context, client *http.Client, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
+
+// isHTMLContentType reports whether a Content-Type header names one of types.
+// A missing header is accepted, since many servers omit it for HTML.
+func isHTMLContentType(header string, types []string) bool {
+	if strings.TrimSpace(header) == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// readPage reads the response body, decompressing gzip content and counting the bytes
+// received on the wire. A body over limit bytes, compressed or decoded, is cut off there and
+// reported as errBodyTooLarge, so a small gzip bomb can't inflate past it either.
+func readPage(resp *http.Response, limit int64) (*fetchedPage, error) {
+	// one byte past the limit tells a body of exactly limit bytes from a bigger one
+	wire := &countingReader{r: io.LimitReader(resp.Body, limit+1)}
+	page := &fetchedPage{ContentEncoding: strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))}
+
+	var r io.Reader = wire
+	if page.ContentEncoding == "gzip" {
+		zr, err := gzip.NewReader(wire)
+		if errors.Is(err, io.EOF) {
+			return page, nil // empty body
+		}
+		if err != nil {
+			return page, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer func() { _ = zr.Close() }()
+		r = zr
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	page.Body = body
+	page.TransferSize = wire.n
+	if int64(wire.n) > limit || int64(len(body)) > limit {
+		page.Body = body[:min(int64(len(body)), limit)]
+		return page, fmt.Errorf("%w: body exceeds the %d-byte limit", errBodyTooLarge, limit)
+	}
+	return page, err
+}
+
+// toUTF8 transcodes an HTML body to UTF-8 using the charset named by a BOM, the
+// Content-Type header, or a <meta charset> within the first 1024 bytes, and returns the
+// charset used. An undeclared or merely meta-declared charset is only applied when the
+// body isn't valid UTF-8 already; such bodies are otherwise left alone and read as UTF-8.
+func toUTF8(body []byte, contentType string) ([]byte, string) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" || (!certain && utf8.Valid(body)) {
+		return body, "utf-8"
+	}
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, "utf-8"
+	}
+	return decoded, name
+}
+
+// compressionRatio returns the decoded to transferred size ratio, or 0 for an empty body.
+func (p *fetchedPage) compressionRatio() float64 {
+	if p.TransferSize == 0 {
+		return 0
+	}
+	return float64(len(p.Body)) / float64(p.TransferSize)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// countHeadings counts the number of headings (h1..h6 and ARIA role="heading") in the document.
+func countHeadings(doc *goquery.Document) HeadingCounts {
+	return countHeadingsIn(doc.Selection)
+}
+
+// countHeadingsIn counts the headings (h1..h6 and ARIA role="heading") below root.
+func countHeadingsIn(root *goquery.Selection) HeadingCounts {
+	counts := HeadingCounts{1: 0, 2: 0, 3: 0, 4: 0, 5: 0, 6: 0}
+
+	// Standard h1..h6
+	for level := 1; level <= 6; level++ {
+		sel := fmt.Sprintf("h%d", level)
+		counts[level] += root.Find(sel).Length()
+	}
+
+	// ARIA role="heading" with aria-level
+	root.Find(`[role="heading"][aria-level]`).Each(func(_ int, s *goquery.Selection) {
+		if lvl := ariaHeadingLevel(s); lvl > 0 {
+			counts[lvl]++
+		}
+	})
+
+	return counts
+}
+
+// countEmptyHeadings counts, per level, the headings (h1..h6 and ARIA role="heading") below
+// root whose text is empty or only whitespace, which screen readers announce as blank.
+func countEmptyHeadings(root *goquery.Selection) HeadingCounts {
+	counts := HeadingCounts{1: 0, 2: 0, 3: 0, 4: 0, 5: 0, 6: 0}
+	root.Find("h1, h2, h3, h4, h5, h6").Each(func(_ int, s *goquery.Selection) {
+		if strings.TrimSpace(s.Text()) == "" {
+			counts[int(goquery.NodeName(s)[1]-'0')]++
+		}
+	})
+	root.Find(`[role="heading"][aria-level]`).Each(func(_ int, s *goquery.Selection) {
+		if lvl := ariaHeadingLevel(s); lvl > 0 && strings.TrimSpace(s.Text()) == "" {
+			counts[lvl]++
+		}
+	})
+	return counts
+}
+
+// ariaHeadingLevel returns the aria-level (1..6) of a role="heading" element, or 0 when it
+// is missing or out of range.
+func ariaHeadingLevel(s *goquery.Selection) int {
+	v, _ := s.Attr("aria-level")
+	switch v = strings.TrimSpace(v); v {
+	case "1", "2", "3", "4", "5", "6":
+		return int(v[0] - '0')
+	}
+	return 0
+}
+
+// firstH1 returns the text of the page's main h1: the first h1 inside the main landmark,
+// falling back to the first h1 in the document.
+func firstH1(doc *goquery.Document, mainContent *goquery.Selection) string {
+	h1 := mainContent.Find("h1").First()
+	if h1.Length() == 0 {
+		h1 = doc.Find("h1").First()
+	}
+	return strings.Join(strings.Fields(h1.Text()), " ")
+}
+
+// sameText reports whether two strings are equal ignoring case and whitespace differences.
+func sameText(a, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
+
+// tagHistogram counts elements by tag name and returns the n most common, most frequent
+// first; ties are ordered by name.
+func tagHistogram(doc *goquery.Document, n int) []TagCount {
+	counts := make(map[string]int)
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		counts[goquery.NodeName(s)]++
+	})
+	hist := make([]TagCount, 0, len(counts))
+	for tag, c := range counts {
+		hist = append(hist, TagCount{Tag: tag, Count: c})
+	}
+	slices.SortFunc(hist, func(a, b TagCount) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	if len(hist) > n {
+		hist = hist[:n]
+	}
+	return hist
+}
+
+// checkIframes counts iframes and those without a non-empty title attribute, which screen
+// readers need to describe the frame.
+func checkIframes(doc *goquery.Document) (count, missingTitle int) {
+	doc.Find("iframe").Each(func(_ int, s *goquery.Selection) {
+		count++
+		if title, _ := s.Attr("title"); strings.TrimSpace(title) == "" {
+			missingTitle++
+		}
+	})
+	return count, missingTitle
+}
+
+// countLoading tallies the loading attribute of the elements matching selector. Anything
+// other than loading="lazy" (including no attribute) loads eagerly.
+func countLoading(doc *goquery.Document, selector string) (c LoadingCounts) {
+	doc.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		if v, _ := s.Attr("loading"); strings.EqualFold(strings.TrimSpace(v), "lazy") {
+			c.Lazy++
+		} else {
+			c.Eager++
+		}
+	})
+	return c
+}
+
+// checkImageAlt counts <img> elements, those without an alt attribute, and those with an
+// empty one (alt="", marking the image as decorative).
+func checkImageAlt(doc *goquery.Document) (count, missing, empty int) {
+	doc.Find("img").Each(func(_ int, s *goquery.Selection) {
+		count++
+		alt, ok := s.Attr("alt")
+		switch {
+		case !ok:
+			missing++
+		case strings.TrimSpace(alt) == "":
+			empty++
+		}
+	})
+	return count, missing, empty
+}
+
+// checkScriptSRI counts <script src> elements loading from another host and those of them
+// without an integrity attribute, which run whatever the third party serves.
+func checkScriptSRI(doc *goquery.Document, base *url.URL) (external, withoutSRI int) {
+	doc.Find("script[src]").Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		u, err := base.Parse(strings.TrimSpace(src))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || sameHost(base, u) {
+			return
+		}
+		external++
+		if v, _ := s.Attr("integrity"); strings.TrimSpace(v) == "" {
+			withoutSRI++
+		}
+	})
+	return external, withoutSRI
+}
+
+// checkTabindex counts elements with a tabindex attribute and those with a positive value,
+// which override the natural tab order.
+func checkTabindex(doc *goquery.Document) (count, positive int) {
+	doc.Find("[tabindex]").Each(func(_ int, s *goquery.Selection) {
+		count++
+		v, _ := s.Attr("tabindex")
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			positive++
+		}
+	})
+	return count, positive
+}
+
+// checkLandmarkCoverage counts the interactive elements (links, buttons and form controls)
+// and those not inside any landmark region, which screen reader users navigating by
+// landmarks can't reach that way.
+func checkLandmarkCoverage(doc *goquery.Document) (count, outside int) {
+	doc.Find(interactiveSelector).Each(func(_ int, s *goquery.Selection) {
+		count++
+		inLandmark := false
+		s.Parents().EachWithBreak(func(_ int, p *goquery.Selection) bool {
+			inLandmark = isLandmark(p)
+			return !inLandmark
+		})
+		if !inLandmark {
+			outside++
+		}
+	})
+	return count, outside
+}
+
+// isLandmark reports whether s is a landmark region: an element with a landmark role, or
+// one whose implicit role is a landmark. <header> and <footer> only are at the top level
+// (not inside sectioning content), <section> and <form> only when they have an
+// accessible name.
+func isLandmark(s *goquery.Selection) bool {
+	if role := strings.Fields(strings.ToLower(s.AttrOr("role", ""))); len(role) > 0 {
+		return landmarkRoles[role[0]]
+	}
+	switch goquery.NodeName(s) {
+	case "main", "nav", "aside", "search":
+		return true
+	case "header", "footer":
+		return s.ParentsFiltered("article, aside, main, nav, section").Length() == 0
+	case "section", "form":
+		return strings.TrimSpace(s.AttrOr("aria-label", "")) != "" || strings.TrimSpace(s.AttrOr("aria-labelledby", "")) != ""
+	}
+	return false
+}
+
+// checkStructuredData counts JSON-LD blocks, microdata items (itemscope) and RDFa resources
+// (typeof) and collects their declared types. RDFa properties on <meta> are left out of
+// RDFaProperties since Open Graph tags use the same attribute.
+func checkStructuredData(doc *goquery.Document) StructuredData {
+	var sd StructuredData
+	types := make(map[string]bool)
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		sd.JSONLD++
+		var v any
+		if json.Unmarshal([]byte(s.Text()), &v) == nil {
+			collectLDTypes(v, types)
+		}
+	})
+	doc.Find("[itemscope]").Each(func(_ int, s *goquery.Selection) {
+		sd.Microdata++
+		t, _ := s.Attr("itemtype")
+		for _, f := range strings.Fields(t) {
+			types[f] = true
+		}
+	})
+	doc.Find("[typeof]").Each(func(_ int, s *goquery.Selection) {
+		sd.RDFa++
+		t, _ := s.Attr("typeof")
+		for _, f := range strings.Fields(t) {
+			types[f] = true
+		}
+	})
+	sd.RDFaProperties = doc.Find("[property]").Not("meta").Length()
+	sd.Types = slices.Sorted(maps.Keys(types))
+	return sd
+}
+
+// collectLDTypes adds the @type values found in a decoded JSON-LD document, including
+// nested objects and @graph entries, to types.
+func collectLDTypes(v any, types map[string]bool) {
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			collectLDTypes(e, types)
+		}
+	case map[string]any:
+		switch t := v["@type"].(type) {
+		case string:
+			types[t] = true
+		case []any:
+			for _, e := range t {
+				if s, ok := e.(string); ok {
+					types[s] = true
+				}
+			}
+		}
+		for k, e := range v {
+			if k != "@type" {
+				collectLDTypes(e, types)
+			}
+		}
+	}
+}
+
+// countHiddenElements counts elements hidden via the hidden attribute, aria-hidden="true",
+// or an inline display:none / visibility:hidden style. Each element is counted once.
+func countHiddenElements(doc *goquery.Document) int {
+	count := 0
+	doc.Find("[hidden], [aria-hidden], [style]").Each(func(_ int, s *goquery.Selection) {
+		if _, ok := s.Attr("hidden"); ok {
+			count++
+			return
+		}
+		if v, ok := s.Attr("aria-hidden"); ok && strings.EqualFold(strings.TrimSpace(v), "true") {
+			count++
+			return
+		}
+		if style, ok := s.Attr("style"); ok {
+			// normalize "display: none" / "visibility : hidden" style spacing
+			style = strings.ToLower(strings.Join(strings.Fields(style), ""))
+			if strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden") {
+				count++
+			}
+		}
+	})
+	return count
+}
+
+// countInlineEventHandlers counts elements carrying inline event-handler attributes
+// (onclick, onload, ...) and tallies each handler name.
+func countInlineEventHandlers(doc *goquery.Document) (elements int, byName map[string]int) {
+	byName = make(map[string]int)
+	doc.Find("*").Each(func(_ int, s *goquery.Selection) {
+		found := false
+		for _, a := range s.Nodes[0].Attr {
+			name := strings.ToLower(a.Key)
+			if len(name) > 2 && strings.HasPrefix(name, "on") {
+				byName[name]++
+				found = true
+			}
+		}
+		if found {
+			elements++
+		}
+	})
+	return elements, byName
+}
+
+// metaContent returns the trimmed content of the first <meta name="..."> with the given name.
+func metaContent(doc *goquery.Document, name string) (string, bool) {
+	var content string
+	found := false
+	doc.Find("meta[name][content]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if n, _ := s.Attr("name"); strings.EqualFold(strings.TrimSpace(n), name) {
+			content, _ = s.Attr("content")
+			content = strings.TrimSpace(content)
+			found = true
+			return false
+		}
+		return true
+	})
+	return content, found
+}
+
+// checkPageMeta collects the meta description and the Open Graph title, description and
+// image of a page, the first declaration of each winning. og:image is resolved against base
+// and left empty when it doesn't parse.
+func checkPageMeta(doc *goquery.Document, base *url.URL) PageMeta {
+	var m PageMeta
+	m.Description, _ = metaContent(doc, "description")
+	m.OGTitle = ogContent(doc, "og:title")
+	m.OGDescription = ogContent(doc, "og:description")
+	if img := ogContent(doc, "og:image"); img != "" {
+		if u, err := base.Parse(img); err == nil {
+			m.OGImage = u.String()
+		}
+	}
+	return m
+}
+
+// ogContent returns the trimmed content of the first <meta property="..."> with the given
+// Open Graph property. Pages that misuse name= for it are accepted too.
+func ogContent(doc *goquery.Document, property string) string {
+	var content string
+	doc.Find("meta[property], meta[name]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		p := s.AttrOr("property", "")
+		if strings.TrimSpace(p) == "" {
+			p = s.AttrOr("name", "")
+		}
+		if strings.EqualFold(strings.TrimSpace(p), property) {
+			content = strings.TrimSpace(s.AttrOr("content", ""))
+			return false
+		}
+		return true
+	})
+	return content
+}
+
+// checkMetaIssues groups <meta> tags by name or property and reports those declared more
+// than once, sorted by key. Tags that may legitimately repeat (repeatableMeta) and tags
+// scoped by a media attribute (e.g. per-color-scheme theme-color) are ignored.
+func checkMetaIssues(doc *goquery.Document) []MetaIssue {
+	values := map[string][]string{}
+	doc.Find("meta[name], meta[property]").Each(func(_ int, s *goquery.Selection) {
+		if _, scoped := s.Attr("media"); scoped {
+			return
+		}
+		key := s.AttrOr("name", "")
+		if strings.TrimSpace(key) == "" {
+			key = s.AttrOr("property", "")
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" || repeatableMeta[key] {
+			return
+		}
+		values[key] = append(values[key], strings.TrimSpace(s.AttrOr("content", "")))
+	})
+
+	var issues []MetaIssue
+	for key, vs := range values {
+		if len(vs) < 2 {
+			continue
+		}
+		issue := MetaIssue{Name: key, Count: len(vs), Values: vs}
+		for _, v := range vs[1:] {
+			if !strings.EqualFold(v, vs[0]) {
+				issue.Conflicting = true
+				break
+			}
+		}
+		issues = append(issues, issue)
+	}
+	slices.SortFunc(issues, func(a, b MetaIssue) int { return strings.Compare(a.Name, b.Name) })
+	return issues
+}
+
+// isNoindex reports whether a robots directive list ("noindex, nofollow", "googlebot: none")
+// keeps the page out of search indexes.
+func isNoindex(directives string) bool {
+	for _, d := range strings.Split(strings.ToLower(directives), ",") {
+		// X-Robots-Tag may scope directives to a crawler: "googlebot: noindex"
+		if i := strings.LastIndex(d, ":"); i >= 0 {
+			d = d[i+1:]
+		}
+		if d = strings.TrimSpace(d); d == "noindex" || d == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRobotsHeader folds X-Robots-Tag header values into the indexability verdict that
+// analyze derived from the robots meta tag. A noindex from either source wins.
+func (r *Analysis) applyRobotsHeader(values []string) {
+	for _, v := range values {
+		if !r.Indexable {
+			return
+		}
+		if isNoindex(v) || r.RobotsDirective == "" {
+			r.Indexable = !isNoindex(v)
+			r.RobotsDirective = "X-Robots-Tag: " + v
+		}
+	}
+}
+
+// checkNoscript counts <noscript> elements and reports whether any of them offers a meaningful
+// fallback (a link or visible text) rather than only tracking pixels or styles.
+func checkNoscript(doc *goquery.Document) (count int, hasFallback bool) {
+	doc.Find("noscript").Each(func(_ int, s *goquery.Selection) {
+		count++
+		// with scripting enabled the parser keeps noscript content as raw text; parse it separately
+		inner, err := goquery.NewDocumentFromReader(strings.NewReader(s.Text()))
+		if err != nil {
+			return
+		}
+		inner.Find("script, style, link, meta").Remove()
+		if inner.Find("a[href]").Length() > 0 || strings.TrimSpace(inner.Find("body").Text()) != "" {
+			hasFallback = true
+		}
+	})
+	return count, hasFallback
+}
+
+// detectLogin looks for a login form: any form with input type=password OR an input whose
+// name contains "password". It stops at the first match and examines at most limit forms;
+// truncated reports that forms were left unexamined because of the limit.
+func detectLogin(doc *goquery.Document, limit int) (hasLogin bool, examined int, truncated bool) {
+	doc.Find("form").EachWithBreak(func(_ int, f *goquery.Selection) bool {
+		if examined >= limit {
+			truncated = true
+			return false
+		}
+		examined++
+		pw := f.Find(`input[type="password"]`).Length()
+		if pw > 0 {
+			hasLogin = true
+			return false
+		}
+		// heuristic: input name contains 'password'
+		match := false
+		f.Find("input").EachWithBreak(func(_ int, in *goquery.Selection) bool {
+			if name, ok := in.Attr("name"); ok && strings.Contains(strings.ToLower(name), "password") {
+				match = true
+				return false
+			}
+			return true
+		})
+		if match {
+			hasLogin = true
+			return false
+		}
+		return true
+	})
+	return hasLogin, examined, truncated
+}
+
+// ampCounterpart returns the other half of an AMP pair: the amphtml link of a canonical page,
+// or the canonical link of an AMP page (<html amp> or <html ⚡>). Empty when none is declared.
+func ampCounterpart(doc *goquery.Document, base *url.URL) string {
+	html := doc.Find("html").First()
+	_, amp := html.Attr("amp")
+	_, bolt := html.Attr("⚡")
+	rel := "amphtml"
+	if amp || bolt {
+		rel = "canonical"
+	}
+	for _, u := range resolveAttr(doc, base, fmt.Sprintf("link[rel=%q][href]", rel), "href") {
+		return u.String()
+	}
+	return ""
+}
+
+// canonicalURLs returns the distinct URLs declared by <link rel="canonical">, resolved
+// against base, in document order.
+func canonicalURLs(doc *goquery.Document, base *url.URL) []string {
+	var canonicals []string
+	for _, u := range resolveAttr(doc, base, `link[rel~="canonical"][href]`, "href") {
+		if c := u.String(); !slices.Contains(canonicals, c) {
+			canonicals = append(canonicals, c)
+		}
+	}
+	return canonicals
+}
+
+// sameDocumentURL reports whether the absolute URL s names the same document as u:
+// fragments are ignored, as are the case of scheme and host and an empty versus "/" path.
+func sameDocumentURL(s string, u *url.URL) bool {
+	c, err := url.Parse(s)
+	if err != nil || u == nil {
+		return false
+	}
+	norm := func(v *url.URL) string {
+		w := *v
+		w.Scheme, w.Host, w.Fragment, w.RawFragment = strings.ToLower(w.Scheme), strings.ToLower(w.Host), "", ""
+		if w.Path == "" {
+			w.Path = "/"
+		}
+		return w.String()
+	}
+	return norm(c) == norm(u)
+}
+
+// feedURLs returns the distinct, resolved URLs of the RSS and Atom feeds the page
+// advertises with <link rel="alternate" type="application/rss+xml"> (or atom+xml).
+func feedURLs(doc *goquery.Document, base *url.URL) []string {
+	var feeds []string
+	doc.Find(`link[rel~="alternate"][href]`).Each(func(_ int, s *goquery.Selection) {
+		typ, _ := s.Attr("type")
+		mt, _, _ := mime.ParseMediaType(typ)
+		if mt != "application/rss+xml" && mt != "application/atom+xml" {
+			return
+		}
+		href, _ := s.Attr("href")
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		if f := u.String(); !slices.Contains(feeds, f) {
+			feeds = append(feeds, f)
+		}
+	})
+	return feeds
+}
+
+// sitemapURLs returns the distinct, resolved URLs of <link rel="sitemap"> tags.
+func sitemapURLs(doc *goquery.Document, base *url.URL) []string {
+	var sitemaps []string
+	for _, u := range resolveAttr(doc, base, `link[rel~="sitemap"][href]`, "href") {
+		if m := u.String(); !slices.Contains(sitemaps, m) {
+			sitemaps = append(sitemaps, m)
+		}
+	}
+	return sitemaps
+}
+
+// compareAMP fetches and analyzes the AMP counterpart of a page (without link checks)
+// and reports how its structure differs from res.
+func compareAMP(ctx context.Context, res *Analysis, opts analyzeOptions) *AMPComparison {
+	cmp := &AMPComparison{URL: res.AMPCounterpart}
+	u, err := url.Parse(res.AMPCounterpart)
+	if err != nil {
+		cmp.Error = err.Error()
+		return cmp
+	}
+	opts.SkipLinkChecks = true
+	resp, page, err := fetch(ctx, u.String(), opts)
+	if err == nil {
+		defer func() { _ = resp.Body.Close() }()
+		var other *Analysis
+		body, _ := toUTF8(page.Body, resp.Header.Get("Content-Type"))
+		if other, err = analyze(ctx, u, body, opts); err == nil {
+			cmp.Title = other.Title
+			cmp.TitleMatches = sameText(other.Title, res.Title)
+			cmp.Headings = other.Headings
+			cmp.HeadingsMatch = maps.Equal(other.Headings, res.Headings)
+			cmp.InternalLinks = other.InternalLinks
+			cmp.ExternalLinks = other.ExternalLinks
+			cmp.LinksMatch = other.InternalLinks == res.InternalLinks && other.ExternalLinks == res.ExternalLinks
+		}
+	}
+	if err != nil {
+		cmp.Error = err.Error()
+	}
+	return cmp
+}
+
+// securityHeaders returns the values of the securityHeaderNames the response set, and the
+// names of those it did not.
+func securityHeaders(h http.Header) (present map[string]string, missing []string) {
+	present = make(map[string]string)
+	for _, name := range securityHeaderNames {
+		if v := h.Get(name); v != "" {
+			present[name] = v
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return present, missing
+}
+
+// hasNoSniff reports whether the response sets X-Content-Type-Options: nosniff, which stops
+// browsers from guessing a content type other than the declared one. Like browsers, only
+// the first value is considered.
+func hasNoSniff(h http.Header) bool {
+	first, _, _ := strings.Cut(h.Get("X-Content-Type-Options"), ",")
+	return strings.EqualFold(strings.TrimSpace(first), "nosniff")
+}
+
+// detectTech fingerprints frameworks and CMSs from the generator meta tag, asset URLs
+// and marker elements (see techSignatures). The result is sorted.
+func detectTech(doc *goquery.Document) []string {
+	generator, _ := metaContent(doc, "generator")
+	generator = strings.ToLower(generator)
+	var assets []string
+	doc.Find("script[src], link[href], img[src]").Each(func(_ int, s *goquery.Selection) {
+		v, ok := s.Attr("src")
+		if !ok {
+			v, _ = s.Attr("href")
+		}
+		assets = append(assets, strings.ToLower(v))
+	})
+
+	var found []string
+	for _, sig := range techSignatures {
+		match := sig.generator != "" && strings.Contains(generator, sig.generator)
+		for _, marker := range sig.assets {
+			match = match || slices.ContainsFunc(assets, func(a string) bool { return strings.Contains(a, marker) })
+		}
+		match = match || (sig.selector != "" && doc.Find(sig.selector).Length() > 0)
+		if match {
+			found = append(found, sig.name)
+		}
+	}
+	slices.Sort(found)
+	return found
+}
+
+// headerTech adds the technologies revealed by response headers to found, keeping it sorted
+// and free of duplicates.
+func headerTech(found []string, h http.Header) []string {
+	for _, sig := range techSignatures {
+		for name, want := range sig.headers {
+			if v := h.Get(name); v != "" && strings.Contains(strings.ToLower(v), want) {
+				found = append(found, sig.name)
+				break
+			}
+		}
+	}
+	slices.Sort(found)
+	return slices.Compact(found)
+}
+
+// detectConsentBanner looks for a cookie-consent banner: a known vendor's script or markup
+// (see consentVendors), or generic cookie-consent markup, in which case vendor is empty.
+func detectConsentBanner(doc *goquery.Document) (found bool, vendor string) {
+	var scripts []string
+	doc.Find("script[src]").Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		scripts = append(scripts, strings.ToLower(src))
+	})
+	for _, v := range consentVendors {
+		for _, marker := range v.scripts {
+			if slices.ContainsFunc(scripts, func(src string) bool { return strings.Contains(src, marker) }) {
+				return true, v.name
+			}
+		}
+		if v.selector != "" && doc.Find(v.selector).Length() > 0 {
+			return true, v.name
+		}
+	}
+	return doc.Find(consentMarkup).Length() > 0, ""
+}
+
+// countFormsWithoutCSRF counts POST forms without a hidden input named like a CSRF token
+// (see csrfFieldPatterns). It is a heuristic: tokens sent in headers, cookies (double-submit)
+// or injected by scripts are not seen, and SameSite cookies may make a token unnecessary.
+func countFormsWithoutCSRF(doc *goquery.Document) int {
+	count := 0
+	doc.Find("form").Each(func(_ int, f *goquery.Selection) {
+		if method, _ := f.Attr("method"); !strings.EqualFold(strings.TrimSpace(method), "post") {
+			return
+		}
+		hasToken := false
+		f.Find(`input[type="hidden" i][name]`).EachWithBreak(func(_ int, in *goquery.Selection) bool {
+			name, _ := in.Attr("name")
+			name = strings.ToLower(name)
+			hasToken = slices.ContainsFunc(csrfFieldPatterns, func(p string) bool { return strings.Contains(name, p) })
+			return !hasToken
+		})
+		if !hasToken {
+			count++
+		}
+	})
+	return count
+}
+
+// checkRoles scans role attributes against the known WAI-ARIA roles. An element counts
+// as invalid when any of its role tokens is unknown, and as deprecated when it uses a
+// deprecated role.
+func checkRoles(doc *goquery.Document) RoleIssues {
+	var issues RoleIssues
+	doc.Find("[role]").Each(func(_ int, s *goquery.Selection) {
+		val, _ := s.Attr("role")
+		invalid, deprecated := false, false
+		for _, tok := range strings.Fields(strings.ToLower(val)) {
+			dep, known := ariaRoles[tok]
+			switch {
+			case !known:
+				invalid = true
+			case dep:
+				deprecated = true
+			}
+		}
+		if strings.TrimSpace(val) == "" {
+			invalid = true
+		}
+		if invalid {
+			issues.Invalid++
+		}
+		if deprecated {
+			issues.Deprecated++
+		}
+		if (invalid || deprecated) && len(issues.Samples) < maxRoleSamples {
+			issues.Samples = append(issues.Samples, val)
+		}
+	})
+	return issues
+}
+
+// analyzeQuick extracts just the title, HTML version and heading counts from body, for
+// quick mode. Links are neither extracted nor checked.
+func analyzeQuick(body []byte) (*Analysis, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+	if title == "" {
+		title = "(no title)"
+	}
+	return &Analysis{
+		Quick:       true,
+		Title:       title,
+		HTMLVersion: detectHTMLVersion(body),
+		Headings:    countHeadings(doc),
+		Indexable:   true,
+	}, nil
+}
+
+// analyze processes the HTML body to extract analysis results.
+func analyze(ctx context.Context, base *url.URL, body []byte, opts analyzeOptions) (*Analysis, error) {
+	cfg := opts.settings()
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+	rawTitle := title
+	if title == "" {
+		title = "(no title)"
+	}
+
+	headings := countHeadings(doc)
+	emptyHeadings := countEmptyHeadings(doc.Selection)
+	mainContent := doc.Find(`main, [role="main"]`)
+	mainHeadings := countHeadingsIn(mainContent)
+	h1 := firstH1(doc, mainContent)
+	hidden := countHiddenElements(doc)
+	iframes, iframesUntitled := checkIframes(doc)
+	imageLoading, iframeLoading := countLoading(doc, "img"), countLoading(doc, "iframe")
+	imageCount, imagesMissingAlt, imagesEmptyAlt := checkImageAlt(doc)
+	externalScripts, scriptsWithoutSRI := checkScriptSRI(doc, base)
+	tabindexCount, positiveTabindex := checkTabindex(doc)
+	interactiveCount, outsideLandmarks := checkLandmarkCoverage(doc)
+	structured := checkStructuredData(doc)
+	tags := tagHistogram(doc, maxTagHistogram)
+	roles := checkRoles(doc)
+	noscriptCount, noscriptFallback := checkNoscript(doc)
+	handlerCount, handlers := countInlineEventHandlers(doc)
+	themeColor, hasThemeColor := metaContent(doc, "theme-color")
+	metaIssues := checkMetaIssues(doc)
+	canonicals := canonicalURLs(doc, base)
+	canonicalTag := ""
+	if len(canonicals) > 0 {
+		canonicalTag = canonicals[0]
+	}
+	colorScheme, hasColorScheme := metaContent(doc, "color-scheme")
+	tech := detectTech(doc)
+	robots, hasRobots := metaContent(doc, "robots")
+	robotsDirective := ""
+	if hasRobots {
+		robotsDirective = fmt.Sprintf("<meta name=%q content=%q>", "robots", robots)
+	}
+	hasConsent, consentVendor := detectConsentBanner(doc)
+
+	var links []link
+	var misleading MisleadingLinks
+	newTab := 0
+	skippedLong := 0
+	skippedSelf := 0
+	skippedSchemes := make(map[string]int)
+	anchors := 0
+	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		href = strings.TrimSpace(href)
+		if len(href) > cfg.MaxURLLength {
+			skippedLong++
+			return
+		}
+		if m := reURLScheme.FindStringSubmatch(href); m != nil {
+			if scheme := strings.ToLower(m[1]); scheme != "http" && scheme != "https" {
+				skippedSchemes[scheme]++
+				return
+			}
+		}
+		if strings.HasPrefix(href, "#") {
+			// a jump within the page is a self link too, so skip_self leaves it out entirely
+			if !opts.SkipSelf {
+				anchors++
+			}
+			return
+		}
+		if href == "" {
+			return
+		}
+		u2, err := base.Parse(href)
+		if err != nil || u2.Scheme == "" || (u2.Scheme != "http" && u2.Scheme != "https") {
+			return
+		}
+		if opts.SkipSelf && isSelfLink(base, u2) {
+			// excluded from every count, check and report; only SelfLinkCount tallies them
+			skippedSelf++
+			return
+		}
+		isInternal := sameHost(base, u2)
+		_, isDownload := s.Attr("download")
+		// scheme-relative hrefs ("//host/path") name the host, so they count as absolute
+		ref, _ := url.Parse(href)
+		isAbsolute := ref != nil && (ref.IsAbs() || ref.Host != "")
+		links = append(links, link{URL: u2, IsInternal: isInternal, IsDownload: isDownload, IsAbsolute: isAbsolute})
+		if target, _ := s.Attr("target"); strings.EqualFold(strings.TrimSpace(target), "_blank") {
+			newTab++
+		}
+		if shown := linkTextHost(s.Text()); shown != nil && !sameHost(shown, u2) {
+			misleading.Count++
+			if len(misleading.Samples) < maxMisleadingSamples {
+				misleading.Samples = append(misleading.Samples, MisleadingLink{Text: strings.TrimSpace(s.Text()), Href: u2.String()})
+			}
+		}
+	})
+
+	referenced := resourceURLs(doc, base)
+	for _, l := range links {
+		referenced = append(referenced, l.URL)
+	}
+	domains := externalDomains(base, referenced)
+
+	internalCount := 0
+	externalCount := 0
+	// fragment-only anchors point at the page itself but never make it into links, so they are
+	// added here; with skip_self they are not counted at all, and skippedSelf holds the self
+	// links left out of links
+	selfCount := skippedSelf + anchors
+	insecureExternal := 0
+	internalAbsolute := 0
+	depths := make(map[int]int)
+	var downloads []string
+	documents := make(map[string]int)
+	for _, l := range links {
+		if l.IsDownload {
+			downloads = append(downloads, l.URL.String())
+		}
+		if ext := strings.ToLower(strings.TrimPrefix(path.Ext(l.URL.Path), ".")); documentExtensions[ext] {
+			documents[ext]++
+		}
+		if l.IsInternal {
+			internalCount++
+			depths[pathDepth(l.URL)]++
+			if l.IsAbsolute {
+				internalAbsolute++
+			}
+		} else {
+			externalCount++
+			if l.URL.Scheme == "http" {
+				insecureExternal++
+			}
+		}
+		if isSelfLink(base, l.URL) {
+			selfCount++
+		}
+	}
+
+	hasLogin, formsExamined, formsTruncated := detectLogin(doc, cfg.MaxForms)
+	formsWithoutCSRF := countFormsWithoutCSRF(doc)
+
+	var linkSum checkSummary
+	if !opts.SkipLinkChecks {
+		linkSum = checkLinks(ctx, links, opts)
+	}
+
+	var internalTitles map[string]string
+	if opts.FetchTitles && !opts.SkipLinkChecks {
+		internalTitles = fetchTitles(ctx, links, opts)
+	}
+
+	var imageSum checkSummary
+	if opts.CheckImages && !opts.SkipLinkChecks {
+		imageSum = checkImages(ctx, imageSources(doc, base), opts)
+	}
+
+	ar := &Analysis{
+		HTMLVersion:                detectHTMLVersion(body),
+		Title:                      title,
+		Headings:                   headings,
+		HasMainLandmark:            mainContent.Length() > 0,
+		MainHeadings:               mainHeadings,
+		EmptyHeadings:              emptyHeadings,
+		H1:                         h1,
+		TitleMatchesH1:             h1 != "" && sameText(rawTitle, h1),
+		InternalLinks:              internalCount,
+		InternalAbsolute:           internalAbsolute,
+		InternalRelative:           internalCount - internalAbsolute,
+		InternalLinkDepthHistogram: depths,
+		ExternalLinks:              externalCount,
+		ExternalByTLD:              externalByTLD(links),
+		LongLinksSkipped:           skippedLong,
+		SkippedSchemes:             skippedSchemes,
+		MailtoLinks:                skippedSchemes["mailto"],
+		TelLinks:                   skippedSchemes["tel"],
+		AnchorLinks:                anchors,
+		MisleadingLinks:            misleading,
+		links:                      links,
+		NewTabLinks:                newTab,
+		InaccessibleLinks:          linkSum.Inaccessible,
+		CheckedLinks:               linkSum.Checked,
+		RedirectingLinks:           linkSum.Redirecting,
+		RedirectSamples:            linkSum.RedirectSamples,
+		LinkChecksDegraded:         linkSum.Degraded || imageSum.Degraded,
+		ChecksSkipped:              linkSum.Skipped + imageSum.Skipped,
+		ChecksTimedOut:             linkSum.TimedOut + imageSum.TimedOut,
+		BudgetExceeded:             errors.Is(ctx.Err(), context.DeadlineExceeded),
+		RequestBudgetHit:           budgetFrom(ctx).wasExhausted(),
+		CheckedLinksCap:            opts.maxLinks(),
+		LinkResults:                linkSum.Results,
+		RobotsDisallowedLinks:      linkSum.Disallowed,
+		HasLogin:                   hasLogin,
+		FormsExamined:              formsExamined,
+		FormsTruncated:             formsTruncated,
+		FormsWithoutCSRF:           formsWithoutCSRF,
+		SelfLinkCount:              selfCount,
+		SelfLinksExcluded:          opts.SkipSelf,
+		InsecureExternalLinks:      insecureExternal,
+		DownloadLinks:              downloads,
+		DocumentLinks:              documents,
+		UniqueDomains:              domains,
+		HiddenElementCount:         hidden,
+		StructuredData:             structured,
+		TabindexCount:              tabindexCount,
+		PositiveTabindexCount:      positiveTabindex,
+		InteractiveElements:        interactiveCount,
+		InteractiveOutsideLandmark: outsideLandmarks,
+		IframeCount:                iframes,
+		IframesMissingTitle:        iframesUntitled,
+		ImageCount:                 imageCount,
+		ImagesMissingAlt:           imagesMissingAlt,
+		ImagesEmptyAlt:             imagesEmptyAlt,
+		ImageLoading:               imageLoading,
+		IframeLoading:              iframeLoading,
+		ExternalScripts:            externalScripts,
+		ScriptsWithoutSRI:          scriptsWithoutSRI,
+		TagHistogram:               tags,
+		InvalidRoles:               roles,
+		NoscriptCount:              noscriptCount,
+		HasNoscriptFallback:        noscriptFallback,
+		InlineEventHandlerCount:    handlerCount,
+		InlineEventHandlers:        handlers,
+		HasThemeColor:              hasThemeColor,
+		ThemeColor:                 themeColor,
+		HasColorScheme:             hasColorScheme,
+		ColorScheme:                colorScheme,
+		Meta:                       checkPageMeta(doc, base),
+		MetaIssues:                 metaIssues,
+		DetectedTech:               tech,
+		AMPCounterpart:             ampCounterpart(doc, base),
+		CanonicalTag:               canonicalTag,
+		Canonicals:                 canonicals,
+		CanonicalConflict:          len(canonicals) > 1,
+		CanonicalMatchesURL:        canonicalTag != "" && sameDocumentURL(canonicalTag, base),
+		Feeds:                      feedURLs(doc, base),
+		Sitemaps:                   sitemapURLs(doc, base),
+		Indexable:                  !isNoindex(robots),
+		RobotsDirective:            robotsDirective,
+		HasConsentBanner:           hasConsent,
+		ConsentVendor:              consentVendor,
+		BrokenImages:               imageSum.Inaccessible,
+		CheckedImages:              imageSum.Checked,
+		CheckedImagesCap:           maxImagesToCheck,
+		InternalTitles:             internalTitles,
+		httpResources:              httpResources(doc),
+		imageChecks:                imageSum,
+	}
+	ar.setMixedContent(base, cfg.MaxListItems)
+	ar.capLists(cfg.MaxListItems)
+	return ar, nil
+}
+
+// httpResources returns the plain-http URLs that images, scripts, iframes and loaded <link>
+// targets (resourceLinkRels) point to, de-duplicated in document order. Relative and
+// scheme-relative references take the page's scheme, so only explicit http:// ones count.
+func httpResources(doc *goquery.Document) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	doc.Find("img[src], script[src], iframe[src], link[href]").Each(func(_ int, s *goquery.Selection) {
+		attr := "src"
+		if goquery.NodeName(s) == "link" {
+			if !slices.ContainsFunc(strings.Fields(strings.ToLower(s.AttrOr("rel", ""))), func(rel string) bool { return resourceLinkRels[rel] }) {
+				return
+			}
+			attr = "href"
+		}
+		u, err := url.Parse(strings.TrimSpace(s.AttrOr(attr, "")))
+		if err != nil || !strings.EqualFold(u.Scheme, "http") || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		urls = append(urls, u.String())
+	})
+	return urls
+}
+
+// imageSources returns the resolved http(s) URLs of all <img src> elements.
+func imageSources(doc *goquery.Document, base *url.URL) []*url.URL {
+	return resolveAttr(doc, base, "img[src]", "src")
+}
+
+// resourceURLs returns the resolved http(s) URLs of subresources the page loads:
+// scripts, images, iframes, <link> targets (stylesheets, fonts, icons) and media sources.
+func resourceURLs(doc *goquery.Document, base *url.URL) []*url.URL {
+	var urls []*url.URL
+	for _, ref := range resourceRefs {
+		urls = append(urls, resolveAttr(doc, base, ref.selector, ref.attr)...)
+	}
+	return urls
+}
+
+// resolveAttr resolves the given attribute of every element matching selector against
+// base, keeping only http(s) URLs.
+func resolveAttr(doc *goquery.Document, base *url.URL, selector, attr string) []*url.URL {
+	var urls []*url.URL
+	doc.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		v, _ := s.Attr(attr)
+		v = strings.TrimSpace(v)
+		if v == "" || strings.HasPrefix(v, "data:") {
+			return
+		}
+		u, err := base.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		urls = append(urls, u)
+	})
+	return urls
+}
+
+// externalDomains returns the sorted, distinct hostnames among urls that are not the page's own host.
+func externalDomains(base *url.URL, urls []*url.URL) []string {
+	seen := make(map[string]struct{})
+	for _, u := range urls {
+		if sameHost(base, u) {
+			continue
+		}
+		seen[strings.ToLower(u.Hostname())] = struct{}{}
+	}
+	return slices.Sorted(maps.Keys(seen))
+}
+
+// linkTextHost parses anchor text that looks like a URL ("https://bank.example/login",
+// "www.bank.example") and returns it, or nil when the text is not a single URL-like token.
+func linkTextHost(text string) *url.URL {
+	fields := strings.Fields(text)
+	if len(fields) != 1 {
+		return nil
+	}
+	text = fields[0]
+	lower := strings.ToLower(text)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		if !strings.HasPrefix(lower, "www.") {
+			return nil
+		}
+		text = "http://" + text
+	}
+	u, err := url.Parse(text)
+	if err != nil || !strings.Contains(u.Hostname(), ".") {
+		return nil
+	}
+	return u
+}
+
+// pathDepth returns the number of non-empty path segments: 0 for "/", 2 for "/a/b/".
+func pathDepth(u *url.URL) int {
+	depth := 0
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// externalByTLD groups external links by their public suffix (".com", ".co.uk", ...).
+// Links to bare IP addresses are grouped under "(ip)".
+func externalByTLD(links []link) map[string]int {
+	byTLD := make(map[string]int)
+	for _, l := range links {
+		if l.IsInternal {
+			continue
+		}
+		host := strings.TrimSuffix(strings.ToLower(l.URL.Hostname()), ".")
+		if host == "" {
+			continue
+		}
+		if net.ParseIP(host) != nil {
+			byTLD["(ip)"]++
+			continue
+		}
+		suffix, _ := publicsuffix.PublicSuffix(host)
+		byTLD["."+suffix]++
+	}
+	return byTLD
+}
+
+// sameHost checks if two URLs share the same host (ignoring "www." prefix).
+func sameHost(a, b *url.URL) bool {
+	ha := strings.ToLower(a.Hostname())
+	hb := strings.ToLower(b.Hostname())
+	// treat "www." as same site for this scope
+	trim := func(s string) string {
+		return strings.TrimPrefix(s, "www.")
+	}
+	return trim(ha) == trim(hb)
+}
+
+// isSelfLink reports whether u points back to the page itself, ignoring the fragment,
+// host case and a trailing slash on an empty path.
+func isSelfLink(page, u *url.URL) bool {
+	norm := func(x *url.URL) string {
+		c := *x
+		c.Fragment = ""
+		c.RawFragment = ""
+		c.Host = strings.ToLower(c.Host)
+		if c.Path == "" {
+			c.Path = "/"
+			c.RawPath = ""
+		}
+		return c.String()
+	}
+	return norm(page) == norm(u)
+}
+
+// checkLinks verifies the accessibility of the provided links concurrently, with the
+// per-link timeout, link cap and worker count from opts.
+func checkLinks(ctx context.Context, links []link, opts analyzeOptions) (sum checkSummary) {
+	ctx, span := tracer.Start(ctx, "checkLinks", trace.WithAttributes(attribute.Int("links.found", len(links))))
+	defer func() {
+		span.SetAttributes(attribute.Int("links.checked", sum.Checked), attribute.Int("links.inaccessible", sum.Inaccessible))
+		span.End()
+		linksChecked.Add(float64(sum.Checked))
+		linksBroken.Add(float64(sum.Inaccessible))
+	}()
+
+	urls := make([]*url.URL, 0, len(links))
+	for _, l := range links {
+		// download links fetch files rather than pages; don't pull them just to check them
+		if l.IsDownload {
+			continue
+		}
+		urls = append(urls, l.URL)
+	}
+	return checkURLs(ctx, uniqueURLs(urls, opts.maxLinks(), opts.settings().stripParams), opts)
+}
+
+// fetchTitles GETs up to maxTitleFetches distinct internal pages among links, reading at
+// most titleReadLimit bytes of each, and returns their <title> keyed by URL. Pages that
+// fail, aren't HTML, answer a non-2xx status or have no title are left out.
+func fetchTitles(ctx context.Context, links []link, opts analyzeOptions) map[string]string {
+	ctx, span := tracer.Start(ctx, "fetchTitles")
+	defer span.End()
+
+	var urls []*url.URL
+	for _, l := range links {
+		if l.IsInternal && !l.IsDownload {
+			urls = append(urls, l.URL)
+		}
+	}
+	cfg := opts.settings()
+	unique := uniqueURLs(urls, maxTitleFetches, cfg.stripParams)
+	titles := make(map[string]string)
+	if len(unique) == 0 {
+		return titles
+	}
+
+	client := &http.Client{
+		Transport: newTransport(cfg, linkDial, 40, 4*time.Second, opts.headerTimeout(), opts.userAgent()),
+		Timeout:   opts.linkTimeout(),
+	}
+	robots := robotsFrom(ctx)
+	jobs := make(chan *url.URL)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	worker := func() {
+		defer wg.Done()
+		for u := range jobs {
+			if robots != nil && !robots.allows(client, u) {
+				continue
+			}
+			if title := fetchTitle(ctx, client, u, cfg.htmlTypes); title != "" {
+				mu.Lock()
+				titles[u.String()] = title
+				mu.Unlock()
+			}
+		}
+	}
+	nw := min(opts.workers(), len(unique))
+	wg.Add(nw)
+	for i := 0; i < nw; i++ {
+		go worker()
+	}
+	for _, u := range unique {
+		select {
+		case jobs <- u:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	span.SetAttributes(attribute.Int("titles.found", len(titles)))
+	return titles
+}
+
+// fetchTitle returns the trimmed <title> within the first titleReadLimit bytes of u, or ""
+// when there is none or u is not an HTML page, as judged by htmlTypes. The limit counts
+// decoded bytes (net/http unzips the body), so a compressed page can't inflate past it.
+func fetchTitle(ctx context.Context, client *http.Client, u *url.URL, htmlTypes []string) string {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = resp.Body.Close() }()
+	ct := resp.Header.Get("Content-Type")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || !isHTMLContentType(ct, htmlTypes) {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, titleReadLimit))
+	if err != nil && len(body) == 0 {
+		return ""
+	}
+	body, _ = toUTF8(body, ct)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Find("title").First().Text())
+}
+
+// checkImages verifies the accessibility of the provided image sources concurrently.
+func checkImages(ctx context.Context, srcs []*url.URL, opts analyzeOptions) checkSummary {
+	return checkURLs(ctx, uniqueURLs(srcs, maxImagesToCheck, opts.settings().stripParams), opts)
+}
+
+// uniqueURLs drops duplicate URLs, keeping the first occurrence, and trims the result to limit.
+// URLs that differ only in the query parameters matched by strip (-strip-params) count as
+// duplicates; the kept URL is unchanged.
+func uniqueURLs(urls []*url.URL, limit int, strip []string) []*url.URL {
+	unique := make([]*url.URL, 0, len(urls))
+	seen := make(map[string]struct{})
+	for _, u := range urls {
+		key := dedupKey(u, strip)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, u)
+	}
+
+	// Trim to cap
+	if len(unique) > limit {
+		unique = unique[:limit]
+	}
+	return unique
+}
+
+// dedupKey returns the URL string with the query parameters matched by strip removed.
+func dedupKey(u *url.URL, strip []string) string {
+	if len(strip) == 0 || u.RawQuery == "" {
+		return u.String()
+	}
+	q := u.Query()
+	for name := range q {
+		if isStrippedParam(name, strip) {
+			q.Del(name)
+		}
+	}
+	k := *u
+	k.RawQuery = q.Encode()
+	return k.String()
+}
+
+// isStrippedParam reports whether a query parameter matches one of the patterns in strip. A
+// trailing "*" matches any suffix, so "utm_*" covers utm_source, utm_medium, ...
+func isStrippedParam(name string, strip []string) bool {
+	for _, p := range strip {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURLs checks the given URLs with a bounded pool of workers and summarizes how many
+// were inaccessible, redirected, and checked before the context expired.
+// Each check is limited by opts.linkTimeout(); at most opts.workers() run at once.
+func checkURLs(ctx context.Context, unique []*url.URL, opts analyzeOptions) checkSummary {
+	var sum checkSummary
+	if len(unique) == 0 {
+		return sum
+	}
+	cfg := opts.settings()
+	timeout := opts.linkTimeout()
+
+	type result struct {
+		from       *url.URL
+		final      *http.Response // body closed; nil when no response arrived
+		err        error
+		broken     bool
+		skipped    bool // refused by the request budget; neither checked nor broken
+		disallowed bool // robots.txt forbids fetching it; not checked
+		timedOut   bool // cut short by the analysis budget; neither checked nor broken
+	}
+	results := make(chan result)
+	var wg sync.WaitGroup
+
+	client := &http.Client{
+		Transport: newTransport(cfg, linkDial, 40, 4*time.Second, opts.headerTimeout(), opts.userAgent()),
+		Timeout:   timeout,
+	}
+
+	// The checks run on the shared link pool, at most nw of this analysis at a time, each
+	// holding a slot of sem. Running out of file descriptors shows up as dial errors.
+	// Affected checks are retried after a backoff, and the slot that hit the limit is
+	// retired (never released) to lower this analysis's concurrency.
+	nw := min(opts.workers(), len(unique))
+	sem := make(chan struct{}, nw)
+	var degraded atomic.Bool
+	var active atomic.Int32
+	active.Store(int32(nw))
+	retire := func() bool {
+		for {
+			n := active.Load()
+			if n <= 1 {
+				return false
+			}
+			if active.CompareAndSwap(n, n-1) {
+				return true
+			}
+		}
+	}
+
+	robots := robotsFrom(ctx)
+	retries := opts.linkRetries()
+
+	check := func(u *url.URL) {
+		defer wg.Done()
+		retired := false
+		defer func() {
+			if !retired {
+				<-sem
+			}
+		}()
+		if robots != nil && !robots.allows(client, u) {
+			select {
+			case results <- result{from: u, disallowed: true}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		ok, final, err := checkLink(ctx, client, u, timeout, cfg.acceptStatus)
+		exhausted := false
+		for attempt := 1; isResourceExhausted(err) && attempt <= resourceRetries; attempt++ {
+			exhausted = true
+			degraded.Store(true)
+			select {
+			case <-time.After(time.Duration(attempt) * resourceBackoff):
+			case <-ctx.Done():
+				return
+			}
+			ok, final, err = checkLink(ctx, client, u, timeout, cfg.acceptStatus)
+		}
+		for attempt := 1; !ok && attempt <= retries && isTransientFailure(final, err); attempt++ {
+			// back off exponentially, but don't spend the last of the analysis budget waiting
+			wait := linkRetryBackoff << (attempt - 1)
+			if deadline, hasDeadline := ctx.Deadline(); hasDeadline && wait > time.Until(deadline)/4 {
+				break
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			ok, final, err = checkLink(ctx, client, u, timeout, cfg.acceptStatus)
+		}
+		skipped := errors.Is(err, errRequestBudget)
+		// a check that failed because the whole analysis ran out of time says nothing about the link
+		timedOut := err != nil && ctx.Err() != nil
+		select {
+		case results <- result{from: u, final: final, err: err, broken: !ok && !skipped && !timedOut, skipped: skipped, timedOut: timedOut}:
+		case <-ctx.Done():
+			return
+		}
+		retired = exhausted && retire()
+	}
+
+	pool := cfg.pool
+	go func() {
+		for _, u := range unique {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			wg.Add(1)
+			if !pool.submit(ctx, func() { check(u) }) {
+				wg.Done()
+				return
+			}
+		}
+	}()
+
+	for sum.Checked+sum.Skipped+sum.Disallowed+sum.TimedOut < len(unique) {
+		select {
+		case r := <-results:
+			if r.skipped {
+				sum.Skipped++
+				continue
+			}
+			if r.timedOut {
+				sum.TimedOut++
+				continue
+			}
+			if r.disallowed {
+				sum.Disallowed++
+				sum.Results = append(sum.Results, LinkResult{URL: r.from.String(), Disallowed: true})
+				continue
+			}
+			sum.Checked++
+			if r.broken {
+				sum.Inaccessible++
+			}
+			lr := LinkResult{URL: r.from.String(), Broken: r.broken}
+			if r.final != nil {
+				lr.Status = r.final.StatusCode
+				lr.Redirected = r.final.Request.URL.String() != lr.URL
+			} else if r.err != nil {
+				lr.Error = r.err.Error()
+			}
+			sum.Results = append(sum.Results, lr)
+			if r.final != nil && r.final.Request.URL.String() != r.from.String() {
+				sum.Redirecting++
+				if len(sum.RedirectSamples) < maxRedirectSamples {
+					hops, initial := redirectHops(r.final)
+					sum.RedirectSamples = append(sum.RedirectSamples, RedirectPair{
+						From:          r.from.String(),
+						To:            r.final.Request.URL.String(),
+						InitialStatus: initial,
+						Hops:          hops,
+						FinalStatus:   r.final.StatusCode,
+					})
+				}
+			}
+		case <-ctx.Done():
+			// budget exceeded; return what we have. Workers see ctx.Done and exit on their own,
+			// and every URL without a result counts as timed out.
+			sum.TimedOut = len(unique) - sum.Checked - sum.Skipped - sum.Disallowed
+			sum.Degraded = degraded.Load()
+			slices.SortFunc(sum.Results, func(a, b LinkResult) int { return strings.Compare(a.URL, b.URL) })
+			return sum
+		}
+	}
+	wg.Wait()
+	sum.Degraded = degraded.Load()
+	sum.Retired = nw - int(active.Load())
+	slices.SortFunc(sum.Results, func(a, b LinkResult) int { return strings.Compare(a.URL, b.URL) })
+	return sum
+}
+
+// isResourceExhausted reports whether err stems from the process or system running out
+// of file descriptors (EMFILE/ENFILE).
+func isResourceExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// isTransientFailure reports whether a failed link check may well succeed when retried:
+// a 5xx response, or no response because the request timed out or the connection was
+// reset. Definitive answers such as 404 are not transient.
+func isTransientFailure(final *http.Response, err error) bool {
+	if final != nil {
+		return final.StatusCode >= 500
+	}
+	if err == nil || errors.Is(err, errRequestBudget) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNRESET) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// redirectHops returns how many redirects were followed to get resp and the status of
+// the first response in the chain (resp's own status without redirects).
+func redirectHops(resp *http.Response) (hops, initialStatus int) {
+	initialStatus = resp.StatusCode
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops++
+		initialStatus = req.Response.StatusCode
+	}
+	return hops, initialStatus
+}
+
+// checkLink tests if a single link is accessible, i.e. answers with a status in accept
+// (-accept-status, HTTP 2xx or 3xx by default). final is the response that answered after following
+// redirects, with its body closed, or nil when no response arrived; err is the transport
+// error in that case.
+func checkLink(ctx context.Context, client *http.Client, u *url.URL, timeout time.Duration, accept statusRanges) (ok bool, final *http.Response, err error) {
+	// never wait past the analysis budget, whatever the per-link timeout
+	if deadline, hasDeadline := ctx.Deadline(); hasDeadline {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Prefer HEAD, fallback to GET when HEAD not allowed
+	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	resp, err := client.Do(req)
+	if err == nil && resp != nil && accept.contains(resp.StatusCode) {
+		_ = resp.Body.Close()
+		return true, resp, nil
+	}
+	// Retry with GET if HEAD failed or got 405/403
+	if resp != nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusForbidden {
+			// treat other non-accepted statuses as bad
+			return false, resp, nil
+		}
+	}
+	req2, _ := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	resp2, err2 := client.Do(req2)
+	if err2 != nil {
+		return false, nil, err2
+	}
+	defer func() {
+		_ = resp2.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp2.Body, 64<<10))
+	return accept.contains(resp2.StatusCode), resp2, nil
+}
+
+// statusRanges is a set of inclusive HTTP status code ranges, written as "200-299,304".
+type statusRanges [][2]int
+
+// parseStatusRanges parses a comma-separated list of status codes and code ranges.
+func parseStatusRanges(s string) (statusRanges, error) {
+	var out statusRanges
+	for _, part := range splitList(s) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("invalid status range %q", part)
+			}
+		}
+		if from < 100 || to > 599 || from > to {
+			return nil, fmt.Errorf("invalid status range %q", part)
+		}
+		out = append(out, [2]int{from, to})
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no status codes given")
+	}
+	return out, nil
+}
+
+// contains reports whether code falls into any of the ranges.
+func (r statusRanges) contains(code int) bool {
+	for _, rg := range r {
+		if code >= rg[0] && code <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the ranges the way parseStatusRanges reads them.
+func (r statusRanges) String() string {
+	parts := make([]string, 0, len(r))
+	for _, rg := range r {
+		if rg[0] == rg[1] {
+			parts = append(parts, strconv.Itoa(rg[0]))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d-%d", rg[0], rg[1]))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,287 @@
+package webanalyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Options configures an Analyzer. Zero fields use the defaults of DefaultConfig. Options
+// about fetching the page (Render, HeadersOnly, MaxRedirects, CookieRefetch) don't apply to
+// AnalyzeHTML.
+type Options struct {
+	LinkTimeout    time.Duration // per link check; default Config.PerRequestTimeout
+	LinkRetries    int           // retries of a link check that timed out or got a 5xx; default linkCheckRetries, negative for none
+	FetchTimeout   time.Duration // page fetch including the body; default Config.FetchTimeout
+	Budget         time.Duration // whole analysis including link checks; default Config.TotalBudget
+	Workers        int           // concurrent link checks; default Config.LinkWorkers
+	MaxLinks       int           // links checked per page; default Config.MaxLinks
+	SkipLinkChecks bool          // don't check links (or images) at all
+	SkipSelf       bool          // leave links back to the page itself out of every count and check
+	IgnoreRobots   bool          // check links even where the site's robots.txt disallows it
+	MetaRefresh    bool          // follow a zero-delay <meta http-equiv="refresh"> once, e.g. on a bounce page
+	AnalyzeErrors  bool          // analyze the body of a page answering 4xx/5xx instead of failing
+	Quick          bool          // read the first quickBodyLimit bytes only, for the title, HTML version and headings; no link checks
+	UserAgent      string        // User-Agent of every request; default Config.UserAgent
+	CheckImages    bool          // also check <img src> URLs
+	FetchTitles    bool          // also fetch the <title> of up to maxTitleFetches internal pages
+	CompareAMP     bool          // also analyze the page's AMP (or canonical) counterpart and compare the two
+	Render         bool          // analyze the DOM rendered by headless Chrome (needs -tags chromedp); ignored with HeadersOnly or Quick
+	HeadersOnly    bool          // audit the response headers only; the body is neither read nor parsed
+	BodyHash       bool          // include the SHA-256 of the page body
+	MaxRedirects   int           // redirect hops followed; default maxRedirects
+	CookieRefetch  bool          // fetch the page twice, sending the cookies set by the first response
+}
+
+// DefaultOptions returns the options the web server uses by default.
+func DefaultOptions() Options {
+	cfg := DefaultConfig()
+	return Options{
+		LinkTimeout:  cfg.PerRequestTimeout,
+		FetchTimeout: cfg.FetchTimeout,
+		Budget:       cfg.TotalBudget,
+		Workers:      cfg.LinkWorkers,
+		MaxLinks:     cfg.MaxLinks,
+	}
+}
+
+// Analyzer fetches and analyzes web pages. It is safe for concurrent use. Each Analyzer has
+// its own robots.txt cache and limit on concurrent link checks, shared by its analyses.
+type Analyzer struct {
+	opts   analyzeOptions
+	budget time.Duration
+}
+
+// New returns an Analyzer configured by opts.
+func New(opts Options) *Analyzer {
+	cfg := DefaultConfig()
+	cfg.CookieRefetch = opts.CookieRefetch
+	if opts.Budget > 0 {
+		cfg.TotalBudget = opts.Budget
+		cfg.PerRequestTimeout = min(cfg.PerRequestTimeout, opts.Budget)
+		cfg.HeaderTimeout = min(cfg.HeaderTimeout, opts.Budget)
+	}
+	a := &Analyzer{
+		opts: analyzeOptions{
+			CheckImages:    opts.CheckImages,
+			FetchTitles:    opts.FetchTitles,
+			LinkTimeout:    opts.LinkTimeout,
+			LinkRetries:    opts.LinkRetries,
+			FetchTimeout:   opts.FetchTimeout,
+			MaxLinks:       opts.MaxLinks,
+			Workers:        opts.Workers,
+			SkipLinkChecks: opts.SkipLinkChecks,
+			SkipSelf:       opts.SkipSelf,
+			IgnoreRobots:   opts.IgnoreRobots,
+			MetaRefresh:    opts.MetaRefresh,
+			AnalyzeErrors:  opts.AnalyzeErrors,
+			Quick:          opts.Quick,
+			UserAgent:      opts.UserAgent,
+			CompareAMP:     opts.CompareAMP,
+			Render:         opts.Render && !opts.HeadersOnly && !opts.Quick,
+			HeadersOnly:    opts.HeadersOnly,
+			BodyHash:       opts.BodyHash,
+			MaxRedirects:   opts.MaxRedirects,
+			cfg:            mustSettings(cfg),
+		},
+		budget: cfg.TotalBudget,
+	}
+	return a
+}
+
+// Result is the analysis of one page. The analysis fields (Title, Headings, InternalLinks,
+// HasLogin, ...) are promoted from the embedded Analysis.
+type Result struct {
+	URL        string `json:"canonicalURL"` // final URL after redirects
+	HTTPStatus int    `json:"httpStatus"`
+	*Analysis
+}
+
+// Analyze fetches rawURL (https:// is assumed without a scheme) and analyzes the page.
+func (a *Analyzer) Analyze(ctx context.Context, rawURL string) (*Result, error) {
+	u, err := normalizeURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	res, err := a.run(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AnalyzeHTML analyzes html as if it had been served at baseURL, which is only used to
+// resolve links and need not be reachable. Nothing is fetched except for link checks.
+func (a *Analyzer) AnalyzeHTML(ctx context.Context, baseURL string, html []byte) (*Result, error) {
+	u, err := normalizeURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	res, err := a.runHTML(ctx, u, html)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// withBudgets bounds ctx by the analysis budget and attaches the outbound request budget
+// and, unless robots.txt is ignored, the robots cache of the Analyzer's settings.
+func (a *Analyzer) withBudgets(ctx context.Context) (context.Context, context.CancelFunc) {
+	cfg := a.opts.settings()
+	ctx, cancel := context.WithTimeout(ctx, a.budget)
+	ctx = withRequestBudget(ctx, cfg.RequestBudget)
+	if !a.opts.IgnoreRobots {
+		ctx = withRobotsCache(ctx, cfg.robots)
+	}
+	return ctx, cancel
+}
+
+// run fetches u and analyzes the response within the analysis and request budgets. The
+// returned Result is never nil: on error it still carries the final URL and, when a
+// response arrived, its status.
+func (a *Analyzer) run(ctx context.Context, u *url.URL) (_ *Result, err error) {
+	defer func(start time.Time) { observeAnalysis(start, err) }(time.Now())
+	ctx, cancel := a.withBudgets(ctx)
+	defer cancel()
+	opts := a.opts
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("url.full", u.String()))
+
+	if opts.Quick {
+		// the title, doctype and headings are near the top; don't download the rest
+		opts.BodyLimit, opts.PrefixOnly = quickBodyLimit, true
+	}
+	out := &Result{URL: u.String()}
+	resp, page, err := fetch(ctx, out.URL, opts)
+	var refreshedFrom string
+	if err == nil && opts.MetaRefresh && !opts.HeadersOnly {
+		resp, page, refreshedFrom, err = followMetaRefresh(ctx, resp, page, opts)
+	}
+	// the document analyzed is the one served last, after redirects and a followed meta
+	// refresh; its links resolve against that URL, not the one asked for
+	base := u
+	if resp != nil {
+		out.HTTPStatus = resp.StatusCode
+		if resp.Request != nil && resp.Request.URL != nil {
+			base = resp.Request.URL
+			out.URL = base.String()
+		}
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", out.HTTPStatus))
+	var statusErr error
+	if errors.Is(err, errNonOKStatus) && opts.AnalyzeErrors && page != nil {
+		// analyze the error page like any other; the status is reported alongside
+		statusErr, err = err, nil
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return out, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var res *Analysis
+	var thumb []byte
+	switch {
+	case opts.HeadersOnly:
+		// audit the response headers only; the body was never read and is not parsed
+		res = &Analysis{HeadersOnly: true, Indexable: true}
+	case opts.Quick:
+		body, name := toUTF8(page.Body, resp.Header.Get("Content-Type"))
+		if res, err = analyzeQuick(body); err != nil {
+			return out, err
+		}
+		res.Charset = name
+	default:
+		body, name := toUTF8(page.Body, resp.Header.Get("Content-Type"))
+		if opts.Render {
+			// analyze the DOM after scripts ran; status and headers still come from fetch
+			body, thumb, err = renderPage(ctx, base.String(), opts)
+			if err != nil {
+				return out, err
+			}
+		}
+		if res, err = analyze(ctx, base, body, opts); err != nil {
+			return out, err
+		}
+		res.Charset = name
+	}
+
+	if opts.Render {
+		// the serialized DOM has no doctype; report the one that was served
+		res.HTMLVersion = detectHTMLVersion(page.Body)
+		res.Rendered = true
+		res.Screenshot = thumb
+	}
+	res.DetectedTech = headerTech(res.DetectedTech, resp.Header)
+	if opts.CompareAMP && res.AMPCounterpart != "" {
+		res.AMP = compareAMP(ctx, res, opts)
+	}
+	res.applyRobotsHeader(resp.Header.Values("X-Robots-Tag"))
+	res.Server = resp.Header.Get("Server")
+	res.SecurityHeaders, res.MissingSecurityHeaders = securityHeaders(resp.Header)
+	res.NoSniff = hasNoSniff(resp.Header)
+	res.TTFBMs = page.TTFB.Milliseconds()
+	res.RedirectLatencyMs = page.RedirectTime.Milliseconds()
+	res.FinalFetchMs = page.FinalFetch.Milliseconds()
+	if opts.BodyHash && !opts.HeadersOnly {
+		sum := sha256.Sum256(page.Body)
+		res.BodyHash = hex.EncodeToString(sum[:])
+	}
+	res.RedirectChain = trimList(res, "redirectChain", page.RedirectChain, opts.settings().MaxListItems)
+	res.MetaRefreshFrom = refreshedFrom
+	if statusErr != nil {
+		res.StatusError = statusErr.Error()
+	}
+	res.RedirectsCapped = page.RedirectsCapped
+	if page.RedirectsCapped {
+		res.RedirectError = fmt.Sprintf("too many redirects: gave up after %d; the last redirect response was analyzed", opts.redirectLimit())
+	}
+	res.TransferSize = page.TransferSize
+	res.DecodedSize = len(page.Body)
+	res.ContentEncoding = page.ContentEncoding
+	res.CompressionRatio = page.compressionRatio()
+	res.AnalyzedAt, res.ToolVersion = time.Now().UTC(), version
+	out.Analysis = res
+	return out, nil
+}
+
+// runHTML analyzes body as if it had been served at base, without fetching the page. The
+// returned Result is never nil and has no HTTP status.
+func (a *Analyzer) runHTML(ctx context.Context, base *url.URL, body []byte) (_ *Result, err error) {
+	defer func(start time.Time) { observeAnalysis(start, err) }(time.Now())
+	ctx, cancel := a.withBudgets(ctx)
+	defer cancel()
+	opts := a.opts
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("url.full", base.String()), attribute.Bool("pasted", true))
+
+	out := &Result{URL: base.String()}
+	res, err := analyze(ctx, base, body, opts)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return out, err
+	}
+	res.Pasted = true
+	res.Charset = "utf-8"
+	if opts.CompareAMP && res.AMPCounterpart != "" {
+		res.AMP = compareAMP(ctx, res, opts)
+	}
+	if opts.BodyHash {
+		sum := sha256.Sum256(body)
+		res.BodyHash = hex.EncodeToString(sum[:])
+	}
+	res.DecodedSize = len(body)
+	res.AnalyzedAt, res.ToolVersion = time.Now().UTC(), version
+	out.Analysis = res
+	return out, nil
+}
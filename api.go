@@ -0,0 +1,265 @@
+package webanalyzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// analysisResponse is the JSON body of /analyze.json: the analysis result fields
+// alongside the final URL and its HTTP status.
+type analysisResponse struct {
+	ID           string `json:"id"` // pass to /recheck.json to re-run the link checks
+	CanonicalURL string `json:"canonicalURL"`
+	HTTPStatus   int    `json:"httpStatus"`
+	*Analysis
+}
+
+// responseFields holds the top-level JSON field names a client may select with fields=.
+var responseFields = func() map[string]bool {
+	b, _ := json.Marshal(analysisResponse{Analysis: &Analysis{}})
+	var m map[string]json.RawMessage
+	_ = json.Unmarshal(b, &m)
+	names := make(map[string]bool, len(m))
+	for k := range m {
+		names[k] = true
+	}
+	return names
+}()
+
+// handleAnalyzeJSON processes the URL analysis request and responds with JSON.
+func (s *server) handleAnalyzeJSON(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleAnalyzeJSON")
+	defer span.End()
+
+	if err := r.ParseForm(); err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("bad URL form: %w", err))
+		return
+	}
+	fields, err := parseFields(r.Form.Get("fields"))
+	if err != nil {
+		writeJSONErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	summary := r.Form.Get("format") == "summary"
+
+	pgData, status := s.runAnalysis(ctx, r)
+	if pgData.Result == nil {
+		if summary {
+			writeText(w, status, "Analysis failed: "+pgData.Error)
+			return
+		}
+		writeJSONErr(w, status, errors.New(pgData.Error))
+		return
+	}
+	if summary {
+		writeText(w, http.StatusOK, summarize(pgData))
+		return
+	}
+
+	writeResult(w, pgData, fields)
+}
+
+// handleRecheckJSON re-runs only the link checks of a stored analysis (id=) and responds
+// with the updated result as JSON. It accepts fields= like /analyze.json.
+func (s *server) handleRecheckJSON(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleRecheckJSON")
+	defer span.End()
+
+	if err := r.ParseForm(); err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("bad form: %w", err))
+		return
+	}
+	fields, err := parseFields(r.Form.Get("fields"))
+	if err != nil {
+		writeJSONErr(w, http.StatusBadRequest, err)
+		return
+	}
+	id := strings.TrimSpace(r.Form.Get("id"))
+	if id == "" {
+		writeJSONErr(w, http.StatusBadRequest, errors.New("please provide an analysis id"))
+		return
+	}
+	pgData, ok := s.cfg.history.get(id)
+	if !ok {
+		writeJSONErr(w, http.StatusNotFound, fmt.Errorf("no stored analysis with id %q", id))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.TotalBudget)
+	defer cancel()
+	pgData = recheckLinks(withRequestBudget(ctx, s.cfg.RequestBudget), pgData)
+	s.cfg.history.replace(id, pgData)
+	writeResult(w, pgData, fields)
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above text/html.
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mt {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// htmlVersionResponse is the JSON body of /api/htmlversion.
+type htmlVersionResponse struct {
+	CanonicalURL string `json:"canonicalURL"`
+	HTTPStatus   int    `json:"httpStatus"`
+	HTMLVersion  string `json:"htmlVersion"`
+}
+
+// handleHTMLVersion reports the HTML version of the page at u= from the doctype in the
+// first htmlVersionPrefix bytes of its body, without analysis or link checks.
+func (s *server) handleHTMLVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleHTMLVersion")
+	defer span.End()
+
+	if err := r.ParseForm(); err != nil {
+		writeJSONErr(w, http.StatusBadRequest, fmt.Errorf("bad URL form: %w", err))
+		return
+	}
+	raw := strings.TrimSpace(r.Form.Get("u"))
+	if raw == "" {
+		writeJSONErr(w, http.StatusBadRequest, errors.New("please provide a URL"))
+		return
+	}
+	u, err := normalizeURL(raw)
+	if err != nil {
+		writeJSONErr(w, http.StatusBadRequest, err)
+		return
+	}
+	logAnalyzedURL(ctx, raw)
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.PerRequestTimeout)
+	defer cancel()
+	resp, page, err := fetch(ctx, u.String(), analyzeOptions{BodyLimit: htmlVersionPrefix, PrefixOnly: true, cfg: s.cfg})
+	if err != nil {
+		slog.WarnContext(ctx, "fetch failed", "url", u.String(), "err", err)
+		writeJSONErr(w, http.StatusBadGateway, err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, _ := json.Marshal(htmlVersionResponse{
+		CanonicalURL: resp.Request.URL.String(),
+		HTTPStatus:   resp.StatusCode,
+		HTMLVersion:  detectHTMLVersion(page.Body),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// writeResult responds with the analysis as JSON, keeping only fields when given.
+func writeResult(w http.ResponseWriter, pgData *pageData, fields []string) {
+	body, err := json.Marshal(analysisResponse{
+		ID:           pgData.ID,
+		CanonicalURL: pgData.CanonicalURL,
+		HTTPStatus:   pgData.HTTPStatus,
+		Analysis:     pgData.Result,
+	})
+	if err == nil && len(fields) > 0 {
+		body, err = selectFields(body, fields)
+	}
+	if err != nil {
+		slog.Error("encoding result", "url", pgData.CanonicalURL, "err", err)
+		writeJSONErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// parseFields splits a comma-separated fields= value and validates each name.
+// An empty value selects all fields.
+func parseFields(raw string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !responseFields[f] {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// selectFields keeps only the given top-level fields of a JSON object.
+func selectFields(body []byte, fields []string) ([]byte, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(body, &all); err != nil {
+		return nil, err
+	}
+	picked := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		picked[f] = all[f]
+	}
+	return json.Marshal(picked)
+}
+
+// summarize renders the key facts of an analysis as one plain-text paragraph for chat bots.
+func summarize(pd *pageData) string {
+	r := pd.Result
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s answered HTTP %d", pd.CanonicalURL, pd.HTTPStatus)
+	if r.HeadersOnly {
+		b.WriteString(" (headers only).")
+		return b.String()
+	}
+	fmt.Fprintf(&b, " with %q (%s)", r.Title, r.HTMLVersion)
+	if r.Quick {
+		b.WriteString(" (quick mode; links not checked).")
+		return b.String()
+	}
+	b.WriteString(". ")
+	fmt.Fprintf(&b, "It has %d internal and %d external links; %d of %d checked links are broken.",
+		r.InternalLinks, r.ExternalLinks, r.InaccessibleLinks, r.CheckedLinks)
+	if r.BudgetExceeded {
+		b.WriteString(" Link check incomplete (budget exceeded).")
+	}
+	if r.HasLogin {
+		b.WriteString(" It has a login form.")
+	} else {
+		b.WriteString(" No login form found.")
+	}
+	return b.String()
+}
+
+// writeText responds with a plain-text body and the given status code.
+func writeText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintln(w, text)
+}
+
+// writeJSONErr responds with {"error": "..."} and the given status code.
+func writeJSONErr(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,209 @@
+// Command webanalyzer serves the web page analyzer UI and JSON API.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jestress/webanalyzer"
+)
+
+const (
+	defaultAddr = ":8080"
+	// shutdownSlack is added to the budget for the default shutdown grace period, so that
+	// analyses running at SIGTERM can finish and send their response.
+	shutdownSlack = 5 * time.Second
+)
+
+// envFlags are the environment variables read at startup and the flags they set. A flag
+// given on the command line wins over its variable.
+var envFlags = []struct{ env, flag string }{
+	{"ADDR", "addr"},
+	{"PER_REQUEST_TIMEOUT", "per-request-timeout"},
+	{"TOTAL_BUDGET", "total-budget"},
+	{"MAX_LINKS", "max-links"},
+	{"LINK_WORKERS", "link-workers"},
+}
+
+// applyEnv sets the flags of fs listed in envFlags from the variables lookup finds, e.g.
+// os.LookupEnv. Call it before fs.Parse so the command line can still override them.
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	for _, e := range envFlags {
+		v, ok := lookup(e.env)
+		if !ok {
+			continue
+		}
+		if err := fs.Set(e.flag, v); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", e.env, v, err)
+		}
+	}
+	return nil
+}
+
+// main runs the web server until it fails. A bad flag, environment variable or setting is
+// reported on stderr with exit status 2, as flag does for usage errors. Programs that only
+// need the analysis should use webanalyzer.New and Analyzer.Analyze instead.
+func main() {
+	if err := run(os.Args[1:], os.LookupEnv); err != nil {
+		fmt.Fprintf(os.Stderr, "webanalyzer: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+// run parses args and the environment variables lookup finds, then serves until SIGINT or
+// SIGTERM. Errors in the configuration are returned before anything listens.
+func run(args []string, lookup func(string) (string, bool)) error {
+	fs := flag.NewFlagSet("webanalyzer", flag.ExitOnError)
+	cfg := webanalyzer.DefaultConfig()
+	addr := fs.String("addr", defaultAddr, "address to listen on, host:port or :port")
+	fs.DurationVar(&cfg.PerRequestTimeout, "per-request-timeout", cfg.PerRequestTimeout, "timeout of each link check")
+	fs.DurationVar(&cfg.TotalBudget, "total-budget", cfg.TotalBudget, "max time for a whole analysis, link checks included")
+	fs.IntVar(&cfg.MaxLinks, "max-links", cfg.MaxLinks, "max links checked per page")
+	fs.IntVar(&cfg.LinkWorkers, "link-workers", cfg.LinkWorkers, "concurrent link checks per analysis")
+	otelEnabled := fs.Bool("otel", false, "emit OpenTelemetry spans (requires -otel-endpoint)")
+	otelEndpoint := fs.String("otel-endpoint", "", "OTLP/HTTP endpoint URL for spans, e.g. http://localhost:4318")
+	fs.BoolVar(&cfg.Render, "render", false, "allow analyzing pages rendered by headless Chrome (requires -tags chromedp); Chrome keeps to -request-budget, -rate and -user-agent but makes its own connections, ignoring the TLS, DNS and header-timeout flags")
+	fs.DurationVar(&cfg.HandlerTimeout, "handler-timeout", cfg.HandlerTimeout, "hard deadline for any request; 504 when exceeded")
+	shutdownGrace := fs.Duration("shutdown-grace", cfg.TotalBudget+shutdownSlack, "how long running requests may take to finish after SIGINT/SIGTERM")
+	fs.BoolVar(&cfg.CookieRefetch, "cookie-refetch", false, "fetch pages twice, sending cookies set by the first response")
+	fs.StringVar(&cfg.AcceptStatus, "accept-status", cfg.AcceptStatus, "status codes/ranges a checked link may return to count as accessible")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "max outbound requests per second across all analyses (0 = unlimited)")
+	fs.StringVar(&cfg.HTMLTypes, "html-types", cfg.HTMLTypes, "comma-separated content types accepted as HTML")
+	fs.StringVar(&cfg.StripParams, "strip-params", "", `comma-separated query params ignored when de-duplicating checked links, e.g. "utm_*,fbclid"`)
+	fs.StringVar(&cfg.WebhookURL, "webhook", "", "URL that receives each analysis result as a JSON POST")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", "", "HMAC-SHA256 key for the "+webanalyzer.WebhookSignatureHeader+" header on webhook posts")
+	fs.IntVar(&cfg.MaxURLLength, "max-url-length", cfg.MaxURLLength, "longest href or redirect URL (bytes) to follow")
+	fs.IntVar(&cfg.MaxListItems, "max-list-items", cfg.MaxListItems, "max entries kept in list fields (domains, download links, link results, ...) of a result")
+	fs.IntVar(&cfg.GlobalLinkWorkers, "global-link-workers", cfg.GlobalLinkWorkers, "max link/image checks running at once across all analyses; 4 × -link-workers unless set")
+	fs.IntVar(&cfg.RequestBudget, "request-budget", cfg.RequestBudget, "max outbound requests per analysis, including link checks (0 = unlimited)")
+	fs.StringVar(&cfg.InsecureTLSHosts, "insecure-tls-hosts", "", "comma-separated hosts whose TLS certificates are not verified (self-signed)")
+	fs.StringVar(&cfg.CABundle, "ca-bundle", "", "PEM file of extra CA certificates trusted for outbound TLS, e.g. a private CA")
+	fs.DurationVar(&cfg.DNSTimeout, "dns-timeout", cfg.DNSTimeout, "max time to resolve a host name, separate from the connect timeout")
+	fs.DurationVar(&cfg.DNSCacheTTL, "dns-cache-ttl", cfg.DNSCacheTTL, "how long a host's resolved addresses are reused across analyses (0 = no caching)")
+	fs.IntVar(&cfg.DNSCacheSize, "dns-cache-size", cfg.DNSCacheSize, "max hosts whose resolved addresses are cached")
+	fs.DurationVar(&cfg.HeaderTimeout, "header-timeout", cfg.HeaderTimeout, "max wait for response headers (time to first byte) of the page fetch and other outbound requests; link checks wait at least their link timeout")
+	fs.DurationVar(&cfg.FetchTimeout, "fetch-timeout", cfg.FetchTimeout, "max time to fetch a page including its body; slow bodies may stream this long")
+	fs.StringVar(&cfg.UserAgent, "user-agent", cfg.UserAgent, "User-Agent header sent on every outbound request")
+	fs.Int64Var(&cfg.MaxBodySize, "max-body-size", cfg.MaxBodySize, "largest page body (bytes, compressed or decoded) fetch reads before failing")
+	fs.BoolVar(&cfg.HTTP1, "http1", false, "speak only HTTP/1.1 to target sites, never HTTP/2")
+	fs.IntVar(&cfg.MaxAnalyses, "max-analyses", cfg.MaxAnalyses, "max analyses running at once; more get 429 Too Many Requests (0 = unlimited)")
+	fs.BoolVar(&cfg.IgnoreRobots, "ignore-robots", false, "check links even where robots.txt disallows it")
+	fs.DurationVar(&cfg.RobotsTTL, "robots-ttl", cfg.RobotsTTL, "how long a host's robots.txt rules are reused across analyses")
+	fs.IntVar(&cfg.RobotsCacheSize, "robots-cache-size", cfg.RobotsCacheSize, "max hosts whose robots.txt rules are cached")
+	fs.BoolVar(&cfg.Metrics, "metrics", false, "serve Prometheus metrics on /metrics")
+	fs.BoolVar(&cfg.AnalyzeErrors, "analyze-errors", false, "analyze the body of pages answering with an error status instead of failing")
+	fs.IntVar(&cfg.MaxForms, "max-forms", cfg.MaxForms, "max forms examined per page by login detection")
+	logLevel := fs.String("log-level", "info", "minimum level of logged messages: debug, info, warn or error")
+	logFormat := fs.String("log-format", "text", "log output format: text or json")
+	if err := applyEnv(fs, lookup); err != nil {
+		return err
+	}
+	_ = fs.Parse(args) // ExitOnError: usage errors exit here
+
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	if _, _, err := net.SplitHostPort(*addr); err != nil {
+		return fmt.Errorf("-addr (ADDR): %w", err)
+	}
+	// defaults derived from other flags follow them unless given explicitly
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if !explicit["handler-timeout"] {
+		cfg.HandlerTimeout = 0
+	}
+	if !explicit["header-timeout"] {
+		cfg.HeaderTimeout = 0
+	}
+	if !explicit["global-link-workers"] {
+		cfg.GlobalLinkWorkers = 0
+	}
+	if !explicit["shutdown-grace"] {
+		*shutdownGrace = cfg.TotalBudget + shutdownSlack
+	}
+	if *otelEnabled && *otelEndpoint == "" {
+		return errors.New("-otel requires -otel-endpoint")
+	}
+	h, err := webanalyzer.NewHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *otelEnabled {
+		shutdown, err := webanalyzer.SetupTracing(context.Background(), *otelEndpoint)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = shutdown(context.Background()) }()
+	}
+
+	s := &http.Server{
+		Addr:              *addr,
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("listening", "addr", *addr)
+	return serve(ctx, s, ln, *shutdownGrace)
+}
+
+// serve runs s on ln until ctx is done, then shuts it down gracefully: it stops accepting
+// connections and gives running requests up to grace to finish before closing them.
+func serve(ctx context.Context, s *http.Server, ln net.Listener, grace time.Duration) error {
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(ln) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "grace", grace)
+	sctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := s.Shutdown(sctx); err != nil {
+		slog.Warn("grace period over; closing remaining connections", "err", err)
+		_ = s.Close()
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
+
+// newLogger returns a logger writing to w at the given level ("debug", "info", "warn" or
+// "error") in the given format: "text" (key=value lines, the console default) or "json".
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q: want debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q: want text or json", format)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jestress/webanalyzer"
+)
+
+// --- Environment variables -----------------------------------------------------
+func TestApplyEnv(t *testing.T) {
+	cfg := webanalyzer.DefaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fs.String("addr", defaultAddr, "")
+	timeout := fs.Duration("per-request-timeout", cfg.PerRequestTimeout, "")
+	budget := fs.Duration("total-budget", cfg.TotalBudget, "")
+	links := fs.Int("max-links", cfg.MaxLinks, "")
+	workers := fs.Int("link-workers", cfg.LinkWorkers, "")
+	env := map[string]string{"ADDR": "127.0.0.1:9090", "TOTAL_BUDGET": "90s", "MAX_LINKS": "300"}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+
+	if err := applyEnv(fs, lookup); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	if err := fs.Parse([]string{"-max-links", "20"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if *addr != "127.0.0.1:9090" || *budget != 90*time.Second || *timeout != cfg.PerRequestTimeout || *workers != cfg.LinkWorkers {
+		t.Fatalf("want the variables applied over the defaults, got %q %s %s %d", *addr, *budget, *timeout, *workers)
+	}
+	if *links != 20 {
+		t.Fatalf("want the command line to win over MAX_LINKS, got %d", *links)
+	}
+
+	env = map[string]string{"LINK_WORKERS": "many"}
+	if err := applyEnv(fs, lookup); err == nil || !strings.Contains(err.Error(), "LINK_WORKERS") {
+		t.Fatalf("want an error naming LINK_WORKERS, got %v", err)
+	}
+}
+
+// --- Startup errors ----------------------------------------------------------
+func TestRun_ConfigErrors(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{env: map[string]string{"LINK_WORKERS": "many"}, want: "LINK_WORKERS"},
+		{args: []string{"-log-level", "loud"}, want: "log level"},
+		{args: []string{"-addr", "8080"}, want: "-addr"},
+		{args: []string{"-max-links", "0"}, want: "-max-links"},
+		{args: []string{"-otel"}, want: "-otel-endpoint"},
+	} {
+		lookup := func(k string) (string, bool) { v, ok := c.env[k]; return v, ok }
+		if err := run(c.args, lookup); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("args %q, env %v: want an error mentioning %q, got %v", c.args, c.env, c.want, err)
+		}
+	}
+}
+
+// --- Graceful shutdown ---------------------------------------------------------
+func TestServe_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond) // an analysis still running at the signal
+		_, _ = w.Write([]byte("done"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, s, ln, 5*time.Second) }()
+
+	type reply struct {
+		body string
+		err  error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			replies <- reply{err: err}
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		replies <- reply{string(b), err}
+	}()
+	<-started
+	cancel()
+
+	if r := <-replies; r.err != nil || r.body != "done" {
+		t.Fatalf("want the running request to finish, got %q, %v", r.body, r.err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("want a clean shutdown, got %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Fatal("want new connections refused after shutdown")
+	}
+}
+
+// --- Logging -----------------------------------------------------------------
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "WARN", "text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "url", "https://example.com")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, `level=WARN msg=shown url=https://example.com`) {
+		t.Fatalf("want only the warning as text, got %q", got)
+	}
+	for _, c := range [][2]string{{"loud", "text"}, {"info", "xml"}} {
+		if _, err := newLogger(&buf, c[0], c[1]); err == nil {
+			t.Errorf("level %q, format %q: want an error", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package webanalyzer
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Config configures the web server. The webanalyzer command fills it from its flags and
+// environment variables; each field notes the flag it comes from. Fields documented as
+// derived are worked out from the others when zero.
+type Config struct {
+	PerRequestTimeout time.Duration // each link check and side request (-per-request-timeout)
+	TotalBudget       time.Duration // a whole analysis, link checks included (-total-budget)
+	MaxLinks          int           // links checked per page (-max-links)
+	LinkWorkers       int           // concurrent link checks per analysis (-link-workers)
+	GlobalLinkWorkers int           // checks running at once across analyses; derived as globalWorkersFactor × LinkWorkers (-global-link-workers)
+	HandlerTimeout    time.Duration // hard deadline of any request; derived as TotalBudget + handlerDeadlineSlack (-handler-timeout)
+	HeaderTimeout     time.Duration // wait for response headers of outbound requests; derived as PerRequestTimeout (-header-timeout)
+	FetchTimeout      time.Duration // page fetch including the body (-fetch-timeout)
+	DNSTimeout        time.Duration // host name resolution (-dns-timeout)
+	DNSCacheTTL       time.Duration // reuse of a host's resolved addresses, 0 for no caching (-dns-cache-ttl)
+	DNSCacheSize      int           // hosts whose addresses are cached (-dns-cache-size)
+	RequestBudget     int           // outbound requests per analysis, 0 for unlimited (-request-budget)
+	Rate              float64       // outbound requests per second across analyses, 0 for unlimited (-rate)
+	MaxAnalyses       int           // analyses running at once, 0 for unlimited (-max-analyses)
+	MaxBodySize       int64         // largest page body read (-max-body-size)
+	MaxURLLength      int           // longest href or redirect URL followed (-max-url-length)
+	MaxListItems      int           // entries kept in list fields of a result (-max-list-items)
+	MaxForms          int           // forms examined by login detection (-max-forms)
+	AcceptStatus      string        // status codes/ranges a checked link may return (-accept-status)
+	HTMLTypes         string        // comma-separated content types accepted as HTML (-html-types)
+	StripParams       string        // comma-separated query params ignored when de-duplicating links (-strip-params)
+	InsecureTLSHosts  string        // comma-separated hosts whose TLS certificates are not verified (-insecure-tls-hosts)
+	CABundle          string        // PEM file of extra trusted CA certificates (-ca-bundle)
+	UserAgent         string        // User-Agent of every outbound request (-user-agent)
+	HTTP1             bool          // never speak HTTP/2 to target sites (-http1)
+	CookieRefetch     bool          // fetch pages twice, sending the cookies of the first (-cookie-refetch)
+	IgnoreRobots      bool          // check links even where robots.txt disallows it (-ignore-robots)
+	RobotsTTL         time.Duration // reuse of a host's robots.txt rules (-robots-ttl)
+	RobotsCacheSize   int           // hosts whose robots.txt rules are cached (-robots-cache-size)
+	AnalyzeErrors     bool          // analyze error pages instead of failing (-analyze-errors)
+	Render            bool          // allow headless rendering; needs -tags chromedp (-render)
+	Metrics           bool          // serve Prometheus metrics on /metrics (-metrics)
+	WebhookURL        string        // receives each result as a JSON POST (-webhook)
+	WebhookSecret     string        // HMAC-SHA256 key signing webhook posts (-webhook-secret)
+}
+
+// DefaultConfig returns the configuration the server runs with when no flag is given,
+// derived fields included.
+func DefaultConfig() Config {
+	return Config{
+		PerRequestTimeout: defaultLinkTimeout,
+		TotalBudget:       defaultBudget,
+		MaxLinks:          defaultMaxLinks,
+		LinkWorkers:       defaultLinkWorkers,
+		GlobalLinkWorkers: globalWorkersFactor * defaultLinkWorkers,
+		HandlerTimeout:    defaultBudget + handlerDeadlineSlack,
+		HeaderTimeout:     defaultLinkTimeout,
+		FetchTimeout:      defaultFetchTimeout,
+		DNSTimeout:        defaultDNSTimeout,
+		DNSCacheTTL:       defaultDNSCacheTTL,
+		DNSCacheSize:      defaultDNSCacheSize,
+		RequestBudget:     defaultRequestBudget,
+		MaxAnalyses:       defaultMaxAnalyses,
+		MaxBodySize:       defaultMaxBodySize,
+		MaxURLLength:      defaultMaxURLLength,
+		MaxListItems:      defaultMaxListItems,
+		MaxForms:          defaultMaxForms,
+		AcceptStatus:      defaultAcceptStatus,
+		HTMLTypes:         defaultHTMLTypes,
+		UserAgent:         defaultUserAgent,
+		RobotsTTL:         defaultRobotsTTL,
+		RobotsCacheSize:   defaultRobotsCacheSize,
+	}
+}
+
+// settings is a validated Config, derived fields filled in and lists parsed, together with
+// the state its analyses share: the outbound rate limiter, the analysis slots, the
+// link-check pool, the DNS and robots.txt caches and the analysis history. Every handler and
+// Analyzer owns one, so they never reconfigure each other.
+type settings struct {
+	Config
+	acceptStatus     statusRanges
+	htmlTypes        []string
+	stripParams      []string
+	insecureTLSHosts []string
+	rootCAs          *x509.CertPool // nil uses the system pool
+	limiter          *rate.Limiter  // nil means unlimited
+	slots            chan struct{}  // a token per running analysis; nil means unlimited
+	pool             *workerPool
+	dns              *dnsCache // nil means no caching
+	robots           *robotsCache
+	history          *analysisHistory
+}
+
+// newSettings validates cfg and builds the settings it describes.
+func newSettings(cfg Config) (*settings, error) {
+	if cfg.PerRequestTimeout <= 0 || cfg.TotalBudget <= 0 {
+		return nil, errors.New("-per-request-timeout (PER_REQUEST_TIMEOUT) and -total-budget (TOTAL_BUDGET) must be positive")
+	}
+	if cfg.PerRequestTimeout > cfg.TotalBudget {
+		return nil, errors.New("-per-request-timeout (PER_REQUEST_TIMEOUT) must not exceed -total-budget (TOTAL_BUDGET)")
+	}
+	if cfg.MaxLinks < 1 {
+		return nil, errors.New("-max-links (MAX_LINKS) must be at least 1")
+	}
+	if cfg.LinkWorkers < 1 {
+		return nil, errors.New("-link-workers (LINK_WORKERS) must be at least 1")
+	}
+	// defaults derived from other settings follow them unless given explicitly
+	if cfg.HandlerTimeout == 0 {
+		cfg.HandlerTimeout = cfg.TotalBudget + handlerDeadlineSlack
+	}
+	if cfg.HeaderTimeout == 0 {
+		cfg.HeaderTimeout = cfg.PerRequestTimeout
+	}
+	if cfg.GlobalLinkWorkers == 0 {
+		cfg.GlobalLinkWorkers = globalWorkersFactor * cfg.LinkWorkers
+	}
+
+	if cfg.MaxURLLength < 1 {
+		return nil, errors.New("-max-url-length must be at least 1")
+	}
+	if cfg.MaxListItems < 1 {
+		return nil, errors.New("-max-list-items must be at least 1")
+	}
+	if cfg.MaxForms < 1 {
+		return nil, errors.New("-max-forms must be at least 1")
+	}
+	if cfg.GlobalLinkWorkers < 1 {
+		return nil, errors.New("-global-link-workers must be at least 1")
+	}
+	if cfg.MaxAnalyses < 0 {
+		return nil, errors.New("-max-analyses must not be negative")
+	}
+	if cfg.RobotsTTL <= 0 || cfg.RobotsCacheSize < 1 {
+		return nil, errors.New("-robots-ttl must be positive and -robots-cache-size at least 1")
+	}
+	if cfg.DNSCacheTTL < 0 || cfg.DNSCacheSize < 1 {
+		return nil, errors.New("-dns-cache-ttl must not be negative and -dns-cache-size must be at least 1")
+	}
+	if cfg.MaxBodySize < 1 {
+		return nil, errors.New("-max-body-size must be at least 1")
+	}
+	if cfg.HeaderTimeout <= 0 || cfg.FetchTimeout <= 0 || cfg.HandlerTimeout <= 0 || cfg.DNSTimeout <= 0 {
+		return nil, errors.New("-header-timeout, -fetch-timeout, -handler-timeout and -dns-timeout must be positive")
+	}
+	if cfg.Render && !renderAvailable {
+		return nil, errors.New("-render requires a build with -tags chromedp")
+	}
+	s := &settings{
+		Config:           cfg,
+		htmlTypes:        splitList(cfg.HTMLTypes),
+		stripParams:      splitList(cfg.StripParams),
+		insecureTLSHosts: splitList(cfg.InsecureTLSHosts),
+		limiter:          newOutboundLimiter(cfg.Rate),
+		pool:             newWorkerPool(cfg.GlobalLinkWorkers),
+		dns:              newDNSCache(cfg.DNSCacheTTL, cfg.DNSCacheSize),
+		robots:           newRobotsCache(cfg.RobotsTTL, cfg.RobotsCacheSize, cfg.PerRequestTimeout, cfg.UserAgent),
+		history:          newAnalysisHistory(maxHistory),
+	}
+	if len(s.htmlTypes) == 0 {
+		return nil, errors.New("-html-types must list at least one content type")
+	}
+	var err error
+	if s.acceptStatus, err = parseStatusRanges(cfg.AcceptStatus); err != nil {
+		return nil, fmt.Errorf("-accept-status: %w", err)
+	}
+	if cfg.CABundle != "" {
+		if s.rootCAs, err = loadCABundle(cfg.CABundle); err != nil {
+			return nil, fmt.Errorf("-ca-bundle: %w", err)
+		}
+	}
+	if cfg.MaxAnalyses > 0 {
+		s.slots = make(chan struct{}, cfg.MaxAnalyses)
+	}
+	return s, nil
+}
+
+// defaultSettings serves analyzeOptions built without settings, e.g. in tests. Handlers
+// and Analyzers always pass their own.
+var defaultSettings = mustSettings(DefaultConfig())
+
+// mustSettings is newSettings for configurations known to be valid.
+func mustSettings(cfg Config) *settings {
+	s, err := newSettings(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// settings returns the settings the options were built under, or defaultSettings.
+func (o analyzeOptions) settings() *settings {
+	if o.cfg != nil {
+		return o.cfg
+	}
+	return defaultSettings
+}
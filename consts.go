@@ -1,4 +1,4 @@
-package main
+package webanalyzer
 
 import (
 	"regexp"
@@ -7,13 +7,196 @@ import (
 )
 
 const (
-	defaultAddr        = ":8080"
-	maxLinksToCheck    = 150 // hard cap to avoid hammering big pages
-	linkCheckWorkers   = 12  // concurrency for link checks
-	perRequestTimeout  = 8 * time.Second
-	totalAnalyzeBudget = 45 * time.Second
+	defaultMaxLinks      = 150     // hard cap to avoid hammering big pages (-max-links)
+	maxImagesToCheck     = 50      // hard cap for optional image checks
+	maxRoleSamples       = 5       // offending role values kept as examples
+	maxRedirectSamples   = 5       // redirecting links kept as examples
+	maxMisleadingSamples = 5       // misleading links kept as examples
+	maxTagHistogram      = 10      // most common tags reported in TagHistogram
+	maxHistory           = 100     // analyses kept in memory for /recheck.json
+	defaultLinkWorkers   = 12      // concurrency for link checks (-link-workers)
+	htmlVersionPrefix    = 4 << 10 // body bytes /api/htmlversion reads to find the doctype
+	maxRedirects         = 10      // redirect hops fetch follows, as net/http does by default
+	resourceRetries      = 3       // retries of a link check that failed with EMFILE/ENFILE
+	defaultLinkTimeout   = 8 * time.Second
+	defaultBudget        = 45 * time.Second
+	resourceBackoff      = 250 * time.Millisecond // multiplied by the attempt number
+	// transient link-check failures (timeouts, 5xx): default and maximum retries, and the
+	// pause before the first retry, doubled for each further one
+	linkCheckRetries = 2
+	maxLinkRetries   = 5
+	linkRetryBackoff = 200 * time.Millisecond
+	// quick=1: body bytes read for the title, doctype and headings
+	quickBodyLimit = 64 << 10
+	// titles=1: internal pages fetched for InternalTitles, and body bytes read from each to find the <title>
+	maxTitleFetches = 20
+	titleReadLimit  = 16 << 10
+	// screenshot viewport (CSS px) and the scale applied for the thumbnail
+	screenshotWidth  = 1280
+	screenshotHeight = 800
+	screenshotScale  = 0.25
+	// webhook delivery: per-attempt timeout, attempts, and the pause before a retry (times the retry number)
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+	webhookBackoff  = 500 * time.Millisecond
+	// handlerDeadlineSlack is added to the budget for the hard per-request deadline.
+	handlerDeadlineSlack = 15 * time.Second
+	// defaults of the other Config fields; see DefaultConfig
+	defaultFetchTimeout    = 30 * time.Second
+	defaultDNSTimeout      = 3 * time.Second
+	defaultDNSCacheTTL     = time.Minute
+	defaultDNSCacheSize    = 1000
+	defaultMaxBodySize     = 4 << 20
+	defaultMaxURLLength    = 4096
+	defaultMaxListItems    = 100
+	defaultMaxForms        = 200
+	defaultRequestBudget   = 500
+	defaultMaxAnalyses     = 8
+	defaultRobotsTTL       = time.Hour
+	defaultRobotsCacheSize = 1000
+	defaultAcceptStatus    = "200-399"
+	defaultHTMLTypes       = "text/html,application/xhtml+xml"
+	defaultUserAgent       = "webanalyzer/1.0 (+https://github.com/jestress/webanalyzer)"
+	// globalWorkersFactor times -link-workers is the default of -global-link-workers.
+	globalWorkersFactor = 4
 )
 
+// resourceRefs are the element/attribute pairs through which a page loads subresources.
+var resourceRefs = []struct{ selector, attr string }{
+	{"script[src]", "src"},
+	{"img[src]", "src"},
+	{"iframe[src]", "src"},
+	{"link[href]", "href"},
+	{"source[src]", "src"},
+	{"video[src]", "src"},
+	{"audio[src]", "src"},
+}
+
+// techSignatures are the heuristics behind DetectedTech. A technology is detected when any
+// of its markers matches: a substring of <meta name="generator">, a substring of an asset
+// URL (script src, link href, img src), a marker element, or a response header whose value
+// contains the given substring ("" matches any value). All substrings are lower-case.
+var techSignatures = []struct {
+	name      string
+	generator string
+	assets    []string
+	selector  string
+	headers   map[string]string
+}{
+	{name: "WordPress", generator: "wordpress", assets: []string{"/wp-content/", "/wp-includes/"}},
+	{name: "Shopify", generator: "shopify", assets: []string{"cdn.shopify.com"}, headers: map[string]string{"X-Shopify-Stage": "", "X-ShopId": ""}},
+	{name: "Wix", generator: "wix.com", assets: []string{"static.wixstatic.com", "static.parastorage.com"}, headers: map[string]string{"X-Wix-Request-Id": ""}},
+	{name: "Drupal", generator: "drupal", assets: []string{"/sites/default/files/", "/core/misc/drupal.js"}, selector: "[data-drupal-selector]", headers: map[string]string{"X-Generator": "drupal", "X-Drupal-Cache": ""}},
+	{name: "Next.js", assets: []string{"/_next/"}, selector: "script#__NEXT_DATA__", headers: map[string]string{"X-Powered-By": "next.js"}},
+	{name: "React", selector: "#__next, [data-reactroot], [data-reactid]"},
+}
+
+// securityHeaderNames are the response headers reported by the header audit.
+var securityHeaderNames = []string{
+	"Strict-Transport-Security",
+	"Content-Security-Policy",
+	"X-Frame-Options",
+	"X-Content-Type-Options",
+	"Referrer-Policy",
+	"Permissions-Policy",
+}
+
+// csrfFieldPatterns are lower-case substrings of hidden input names that look like CSRF tokens.
+var csrfFieldPatterns = []string{"csrf", "xsrf", "_token", "authenticity_token", "requestverificationtoken"}
+
+// documentExtensions are the lower-case file extensions, without the dot, of links that
+// DocumentLinks counts as downloadable documents.
+var documentExtensions = map[string]bool{
+	"pdf": true, "doc": true, "docx": true, "xls": true, "xlsx": true, "ppt": true, "pptx": true,
+	"odt": true, "ods": true, "odp": true, "rtf": true, "csv": true, "epub": true,
+	"zip": true, "gz": true, "tgz": true, "7z": true, "rar": true,
+}
+
+// resourceLinkRels are the <link rel> values whose href the browser loads with the page,
+// and so can be mixed content; canonical, alternate and similar links only name a URL.
+var resourceLinkRels = map[string]bool{
+	"stylesheet": true, "icon": true, "apple-touch-icon": true, "mask-icon": true, "manifest": true,
+	"preload": true, "modulepreload": true, "prefetch": true,
+}
+
+// repeatableMeta are meta names/properties that may legitimately appear more than once,
+// so MetaIssues doesn't report them.
+var repeatableMeta = map[string]bool{
+	"og:image": true, "og:image:url": true, "og:image:secure_url": true, "og:image:type": true,
+	"og:image:width": true, "og:image:height": true, "og:image:alt": true,
+	"og:video": true, "og:audio": true, "og:locale:alternate": true,
+	"article:tag": true, "article:author": true, "book:author": true, "book:tag": true,
+	"video:actor": true, "video:tag": true, "music:song": true, "music:musician": true,
+	"citation_author": true,
+}
+
+// consentVendors identify cookie-consent managers by script host or banner markup.
+var consentVendors = []struct {
+	name     string
+	scripts  []string // lower-case substrings of script src
+	selector string
+}{
+	{name: "OneTrust", scripts: []string{"cdn.cookielaw.org", "optanon.blob.core.windows.net", "otsdkstub.js"}, selector: "#onetrust-banner-sdk, #onetrust-consent-sdk"},
+	{name: "Cookiebot", scripts: []string{"consent.cookiebot.com"}, selector: "#CybotCookiebotDialog"},
+	{name: "Didomi", scripts: []string{"sdk.privacy-center.org"}, selector: "#didomi-host"},
+	{name: "Quantcast Choice", scripts: []string{"cmp.quantcast.com", "quantcast.mgr.consensu.org"}},
+	{name: "TrustArc", scripts: []string{"consent.trustarc.com"}, selector: "#truste-consent-track"},
+	{name: "Usercentrics", scripts: []string{"app.usercentrics.eu", "web.cmp.usercentrics.eu"}, selector: "#usercentrics-root"},
+	{name: "CookieYes", scripts: []string{"cdn-cookieyes.com"}},
+	{name: "Osano", scripts: []string{"cmp.osano.com"}, selector: ".cc-window"},
+}
+
+// consentMarkup matches hand-rolled consent banners that no vendor signature covers.
+const consentMarkup = `[id*="cookie-consent"], [class*="cookie-consent"], [id*="cookie-banner"], [class*="cookie-banner"], [id*="cookieconsent"], [class*="cookieconsent"]`
+
+// landmarkRoles are the WAI-ARIA landmark roles.
+var landmarkRoles = map[string]bool{
+	"banner": true, "complementary": true, "contentinfo": true, "form": true,
+	"main": true, "navigation": true, "region": true, "search": true,
+}
+
+// interactiveSelector matches the elements checkLandmarkCoverage counts as interactive.
+const interactiveSelector = `a[href], button, input:not([type="hidden" i]), select, textarea`
+
+// ariaRoles is the set of WAI-ARIA 1.2 roles, including the DPUB and graphics modules.
+// Deprecated roles map to true.
+var ariaRoles = map[string]bool{
+	// abstract-free document structure & widget roles
+	"alert": false, "alertdialog": false, "application": false, "article": false, "banner": false,
+	"blockquote": false, "button": false, "caption": false, "cell": false, "checkbox": false,
+	"code": false, "columnheader": false, "combobox": false, "complementary": false, "contentinfo": false,
+	"definition": false, "deletion": false, "dialog": false, "directory": true, "document": false,
+	"emphasis": false, "feed": false, "figure": false, "form": false, "generic": false,
+	"grid": false, "gridcell": false, "group": false, "heading": false, "img": false,
+	"insertion": false, "link": false, "list": false, "listbox": false, "listitem": false,
+	"log": false, "main": false, "marquee": false, "math": false, "menu": false,
+	"menubar": false, "menuitem": false, "menuitemcheckbox": false, "menuitemradio": false, "meter": false,
+	"navigation": false, "none": false, "note": false, "option": false, "paragraph": false,
+	"presentation": false, "progressbar": false, "radio": false, "radiogroup": false, "region": false,
+	"row": false, "rowgroup": false, "rowheader": false, "scrollbar": false, "search": false,
+	"searchbox": false, "separator": false, "slider": false, "spinbutton": false, "status": false,
+	"strong": false, "subscript": false, "superscript": false, "switch": false, "tab": false,
+	"table": false, "tablist": false, "tabpanel": false, "term": false, "textbox": false,
+	"time": false, "timer": false, "toolbar": false, "tooltip": false, "tree": false,
+	"treegrid": false, "treeitem": false,
+	// graphics module
+	"graphics-document": false, "graphics-object": false, "graphics-symbol": false,
+	// DPUB module
+	"doc-abstract": false, "doc-acknowledgments": false, "doc-afterword": false, "doc-appendix": false,
+	"doc-backlink": false, "doc-biblioentry": true, "doc-bibliography": false, "doc-biblioref": false,
+	"doc-chapter": false, "doc-colophon": false, "doc-conclusion": false, "doc-cover": false,
+	"doc-credit": false, "doc-credits": false, "doc-dedication": false, "doc-endnote": true,
+	"doc-endnotes": false, "doc-epigraph": false, "doc-epilogue": false, "doc-errata": false,
+	"doc-example": false, "doc-footnote": false, "doc-foreword": false, "doc-glossary": false,
+	"doc-glossref": false, "doc-index": false, "doc-introduction": false, "doc-noteref": false,
+	"doc-notice": false, "doc-pagebreak": false, "doc-pagefooter": false, "doc-pageheader": false,
+	"doc-pagelist": false, "doc-part": false, "doc-preface": false, "doc-prologue": false,
+	"doc-pullquote": false, "doc-qna": false, "doc-subtitle": false, "doc-tip": false, "doc-toc": false,
+}
+
+// reURLScheme matches the scheme of an absolute URL reference (RFC 3986, section 3.1).
+var reURLScheme = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+
 var reDoctypeFull = regexp.MustCompile(`(?is)<!DOCTYPE\s+html(?:\s+PUBLIC\s+"([^"]*)"(?:\s+"([^"]*)")?)?.*>`)
 
 // detectHTMLVersion inspects the HTML doctype to determine the HTML version.
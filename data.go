@@ -1,33 +1,344 @@
-package main
+package webanalyzer
 
-import "net/url"
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // pageData holds all data related to a single page analysis session.
 type pageData struct {
+	ID           string // history ID, usable with /recheck.json
 	InputURL     string
 	CanonicalURL string
 	HTTPStatus   int
 	Error        string
-	Result       *analysisResult
+	Result       *Analysis
+	Options      analyzeOptions
 	PerRequestTO int
 	Budget       int
+	MaxRedirects int
+	// RenderEnabled shows the headless rendering option in the form.
+	RenderEnabled bool
+	// Busy marks a request turned away because too many analyses were running.
+	Busy bool
 }
 
-// analysisResult holds the results of analyzing a single page.
-type analysisResult struct {
-	HTMLVersion       string
-	Title             string
-	Headings          map[int]int // level => count
-	InternalLinks     int
-	ExternalLinks     int
-	InaccessibleLinks int
-	CheckedLinks      int
-	CheckedLinksCap   int
-	HasLogin          bool
+// Analysis holds the results of analyzing a single page. Result embeds it, and the JSON
+// API and webhook payloads carry the same fields.
+type Analysis struct {
+	HTMLVersion                string            `json:"htmlVersion"`
+	Charset                    string            `json:"charset"`         // charset the body was decoded from, e.g. "shift_jis"; "utf-8" when undeclared
+	HeadersOnly                bool              `json:"headersOnly"`     // only the header-derived fields below are populated
+	Pasted                     bool              `json:"pasted"`          // analyzed HTML pasted into the form; nothing was fetched and httpStatus is 0
+	Quick                      bool              `json:"quick"`           // quick mode: only title, htmlVersion and headings (from the first 64 KiB) are populated; links were not checked
+	StatusError                string            `json:"statusError"`     // why the fetch failed, e.g. "non-OK status: 500 Internal Server Error", when the error page was analyzed anyway (analyze_errors=1)
+	Server                     string            `json:"server"`          // Server response header
+	SecurityHeaders            map[string]string `json:"securityHeaders"` // security header => value, for those the response set
+	MissingSecurityHeaders     []string          `json:"missingSecurityHeaders"`
+	NoSniff                    bool              `json:"noSniff"`           // X-Content-Type-Options: nosniff is set
+	TTFBMs                     int64             `json:"ttfbMs"`            // time to first byte of the final response
+	RedirectLatencyMs          int64             `json:"redirectLatencyMs"` // spent following redirects before the final page was requested
+	FinalFetchMs               int64             `json:"finalFetchMs"`      // final page, from its request to the end of the body
+	Title                      string            `json:"title"`
+	Indexable                  bool              `json:"indexable"`       // no noindex/none in the robots meta tag or X-Robots-Tag header
+	RobotsDirective            string            `json:"robotsDirective"` // the meta tag or header that decided Indexable; empty when neither is present
+	Headings                   HeadingCounts     `json:"headings"`        // level => count
+	HasMainLandmark            bool              `json:"hasMainLandmark"` // page has <main> or role="main"
+	MainHeadings               HeadingCounts     `json:"mainHeadings"`    // level => count, inside the main landmark only
+	EmptyHeadings              HeadingCounts     `json:"emptyHeadings"`   // level => headings with no text
+	H1                         string            `json:"h1"`              // text of the main h1 (inside <main> when present)
+	TitleMatchesH1             bool              `json:"titleMatchesH1"`  // title and h1 are the same text, ignoring case and whitespace
+	InternalLinks              int               `json:"internalLinks"`
+	InternalAbsolute           int               `json:"internalAbsolute"`           // internal links written with a scheme or host
+	InternalRelative           int               `json:"internalRelative"`           // internal links written as relative paths
+	InternalLinkDepthHistogram map[int]int       `json:"internalLinkDepthHistogram"` // path segments => internal links
+	ExternalLinks              int               `json:"externalLinks"`
+	ExternalByTLD              map[string]int    `json:"externalByTLD"`         // public suffix (e.g. ".co.uk") => external links
+	LongLinksSkipped           int               `json:"longLinksSkipped"`      // hrefs over -max-url-length, ignored entirely
+	SkippedSchemes             map[string]int    `json:"skippedSchemes"`        // non-http(s) scheme (e.g. "mailto", "tel") => anchors using it; not counted as links
+	MailtoLinks                int               `json:"mailtoLinks"`           // mailto: anchors, also in SkippedSchemes; never checked
+	TelLinks                   int               `json:"telLinks"`              // tel: anchors, also in SkippedSchemes; never checked
+	AnchorLinks                int               `json:"anchorLinks"`           // fragment-only anchors (href="#..."), jumping within the page; never checked, and not counted with skip_self
+	MisleadingLinks            MisleadingLinks   `json:"misleadingLinks"`       // link text shows one host, href goes to another
+	NewTabLinks                int               `json:"newTabLinks"`           // links with target="_blank"
+	SelfLinkCount              int               `json:"selfLinkCount"`         // anchors (ignoring fragment) pointing back to the analyzed page at its final URL, "#..." ones included unless skip_self
+	SelfLinksExcluded          bool              `json:"selfLinksExcluded"`     // skip_self=1: self links are left out of all other link counts and checks
+	InsecureExternalLinks      int               `json:"insecureExternalLinks"` // external links using plain http://
+	MixedContent               []string          `json:"mixedContent"`          // plain-http images, scripts, stylesheets and iframes of an HTTPS page
+	MixedContentCount          int               `json:"mixedContentCount"`     // all of them, including any dropped from MixedContent by -max-list-items
+	DownloadLinks              []string          `json:"downloadLinks"`         // <a download> targets; not included in link checks
+	DocumentLinks              map[string]int    `json:"documentLinks"`         // document extension (e.g. "pdf", "docx", "zip") => links to such files
+	UniqueDomains              []string          `json:"uniqueDomains"`         // distinct external hosts across links and resources
+	Omitted                    map[string]int    `json:"omitted"`               // list field (JSON name) => entries dropped by -max-list-items
+	InaccessibleLinks          int               `json:"inaccessibleLinks"`
+	CheckedLinks               int               `json:"checkedLinks"`
+	CheckedLinksCap            int               `json:"checkedLinksCap"`
+	RobotsDisallowedLinks      int               `json:"robotsDisallowedLinks"` // links not checked because robots.txt forbids it
+	LinkResults                []LinkResult      `json:"linkResults"`           // one entry per checked link, sorted by URL
+	RedirectingLinks           int               `json:"redirectingLinks"`      // checked links whose final URL differs from the linked one
+	RedirectSamples            []RedirectPair    `json:"redirectSamples"`       // first few redirecting links
+	LinkChecksDegraded         bool              `json:"linkChecksDegraded"`    // checks were retried/slowed after running out of file descriptors
+	ChecksSkipped              int               `json:"checksSkipped"`         // link/image checks skipped once the request budget ran out
+	ChecksTimedOut             int               `json:"checksTimedOut"`        // link/image checks left unfinished when the analysis budget ran out
+	BudgetExceeded             bool              `json:"budgetExceeded"`        // the analysis budget ran out during link/image checks or title fetches; the results are partial
+	RequestBudgetHit           bool              `json:"requestBudgetHit"`      // the analysis used up -request-budget outbound requests
+	HasLogin                   bool              `json:"hasLogin"`
+	FormsExamined              int               `json:"formsExamined"`      // forms inspected before login detection stopped
+	FormsTruncated             bool              `json:"formsTruncated"`     // stopped at the -max-forms cap without examining every form
+	FormsWithoutCSRF           int               `json:"formsWithoutCSRF"`   // POST forms lacking a hidden CSRF-token-like field (heuristic)
+	Rendered                   bool              `json:"rendered"`           // analyzed the DOM rendered by headless Chrome
+	Screenshot                 []byte            `json:"screenshot"`         // JPEG thumbnail of the rendered page (base64 in JSON)
+	HiddenElementCount         int               `json:"hiddenElementCount"` // hidden attr, aria-hidden="true", or inline display:none/visibility:hidden
+	StructuredData             StructuredData    `json:"structuredData"`
+	TabindexCount              int               `json:"tabindexCount"`
+	PositiveTabindexCount      int               `json:"positiveTabindexCount"`      // tabindex > 0, an accessibility anti-pattern
+	InteractiveElements        int               `json:"interactiveElements"`        // links, buttons and form controls
+	InteractiveOutsideLandmark int               `json:"interactiveOutsideLandmark"` // interactive elements not inside any landmark region (main, nav, banner, ...)
+	IframeCount                int               `json:"iframeCount"`
+	IframesMissingTitle        int               `json:"iframesMissingTitle"` // iframes without a non-empty title attribute
+	ImageCount                 int               `json:"imageCount"`
+	ImagesMissingAlt           int               `json:"imagesMissingAlt"` // <img> without an alt attribute
+	ImagesEmptyAlt             int               `json:"imagesEmptyAlt"`   // <img alt=""> (or whitespace), i.e. decorative
+	ImageLoading               LoadingCounts     `json:"imageLoading"`
+	IframeLoading              LoadingCounts     `json:"iframeLoading"`
+	ExternalScripts            int               `json:"externalScripts"`   // <script src> from another host
+	ScriptsWithoutSRI          int               `json:"scriptsWithoutSRI"` // external scripts without an integrity attribute
+	TagHistogram               []TagCount        `json:"tagHistogram"`      // most common element names, most frequent first
+	InvalidRoles               RoleIssues        `json:"invalidRoles"`
+	NoscriptCount              int               `json:"noscriptCount"`
+	HasNoscriptFallback        bool              `json:"hasNoscriptFallback"`     // a <noscript> contains a link or visible text
+	InlineEventHandlerCount    int               `json:"inlineEventHandlerCount"` // elements with on* attributes (blocked by strict CSPs)
+	InlineEventHandlers        map[string]int    `json:"inlineEventHandlers"`     // handler attribute => occurrences
+	HasThemeColor              bool              `json:"hasThemeColor"`           // <meta name="theme-color">
+	ThemeColor                 string            `json:"themeColor"`
+	HasColorScheme             bool              `json:"hasColorScheme"` // <meta name="color-scheme">
+	ColorScheme                string            `json:"colorScheme"`
+	Meta                       PageMeta          `json:"meta"`                // meta description and Open Graph tags
+	MetaIssues                 []MetaIssue       `json:"metaIssues"`          // meta names/properties declared more than once
+	Canonicals                 []string          `json:"canonicals"`          // distinct <link rel="canonical"> URLs, resolved
+	CanonicalTag               string            `json:"canonicalTag"`        // first declared canonical URL, resolved; empty when none
+	CanonicalMatchesURL        bool              `json:"canonicalMatchesURL"` // CanonicalTag names the fetched (final) URL itself
+	CanonicalConflict          bool              `json:"canonicalConflict"`   // more than one distinct canonical URL is declared
+	Feeds                      []string          `json:"feeds"`               // RSS/Atom feed URLs from <link rel="alternate">, resolved
+	Sitemaps                   []string          `json:"sitemaps"`            // <link rel="sitemap"> URLs, resolved
+	DetectedTech               []string          `json:"detectedTech"`        // frameworks/CMSs fingerprinted from markup and headers
+	AMPCounterpart             string            `json:"ampCounterpart"`      // amphtml link of a canonical page, or canonical link of an AMP page
+	AMP                        *AMPComparison    `json:"amp"`                 // only with amp=1 and a declared counterpart
+	HasConsentBanner           bool              `json:"hasConsentBanner"`    // a cookie-consent manager or banner markup was found
+	ConsentVendor              string            `json:"consentVendor"`       // e.g. "OneTrust"; empty for unrecognized banners
+	BrokenImages               int               `json:"brokenImages"`        // only populated when image checking is enabled
+	CheckedImages              int               `json:"checkedImages"`
+	CheckedImagesCap           int               `json:"checkedImagesCap"`
+	InternalTitles             map[string]string `json:"internalTitles"`   // internal page URL => <title>; only with titles=1
+	TransferSize               int               `json:"transferSize"`     // bytes received on the wire (compressed when ContentEncoding is set)
+	DecodedSize                int               `json:"decodedSize"`      // bytes after decompression
+	ContentEncoding            string            `json:"contentEncoding"`  // e.g. "gzip"; empty for identity
+	CompressionRatio           float64           `json:"compressionRatio"` // DecodedSize / TransferSize; 1 when uncompressed
+	RedirectChain              []RedirectHop     `json:"redirectChain"`    // URLs fetched on the way to the final page, starting with the requested one; empty without redirects
+	RedirectsCapped            bool              `json:"redirectsCapped"`  // the hop limit stopped redirect following; the last redirect response was analyzed
+	RedirectError              string            `json:"redirectError"`    // why redirect following stopped early; empty unless RedirectsCapped
+	MetaRefreshFrom            string            `json:"metaRefreshFrom"`  // bounce page whose zero-delay meta refresh was followed (follow_refresh=1); the analysis is of its target
+	BodyHash                   string            `json:"bodyHash"`         // hex SHA-256 of the decoded body as fetched; only with hash=1
+	AnalyzedAt                 time.Time         `json:"analyzedAt"`       // when the analysis finished, UTC
+	ToolVersion                string            `json:"toolVersion"`      // webanalyzer build that produced the result (see version)
+	imageChecks                checkSummary      // image check outcome, added back into the totals by recheckLinks
+	httpResources              []string          // plain-http subresources, uncapped; see setMixedContent
+	links                      []link            // extracted links, kept for /recheck.json
+}
+
+// HeadingCounts maps a heading level (1..6) to its count. It serializes with stable
+// "h1".."h6" keys.
+type HeadingCounts map[int]int
+
+func (h HeadingCounts) MarshalJSON() ([]byte, error) {
+	m := make(map[string]int, len(h))
+	for lvl, n := range h {
+		m["h"+strconv.Itoa(lvl)] = n
+	}
+	return json.Marshal(m)
+}
+
+func (h *HeadingCounts) UnmarshalJSON(b []byte) error {
+	var m map[string]int
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*h = make(HeadingCounts, len(m))
+	for k, n := range m {
+		lvl, err := strconv.Atoi(strings.TrimPrefix(k, "h"))
+		if err != nil || !strings.HasPrefix(k, "h") {
+			return fmt.Errorf("invalid heading key %q", k)
+		}
+		(*h)[lvl] = n
+	}
+	return nil
+}
+
+// RedirectPair records a checked link, the URL it finally resolved to, and how it got there.
+type RedirectPair struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	InitialStatus int    `json:"initialStatus"` // status of the link itself, e.g. 301
+	Hops          int    `json:"hops"`          // redirects followed
+	FinalStatus   int    `json:"finalStatus"`   // status of To
+}
+
+// RedirectHop is one URL fetched while following the page's redirects, the status it
+// answered with (301 and 302 mean different things to search engines) and how long it took.
+type RedirectHop struct {
+	URL        string `json:"url"`
+	Status     int    `json:"status"`
+	DurationMs int64  `json:"durationMs"` // from sending the request to the response headers
+}
+
+// checkSummary aggregates the outcome of checking a batch of URLs.
+type checkSummary struct {
+	Inaccessible    int
+	Checked         int
+	Redirecting     int
+	RedirectSamples []RedirectPair
+	Degraded        bool         // hit EMFILE/ENFILE and backed off
+	Retired         int          // worker slots given up after hitting EMFILE/ENFILE
+	Skipped         int          // not checked because the request budget ran out
+	TimedOut        int          // not (fully) checked before the analysis budget ran out
+	Disallowed      int          // not checked because robots.txt forbids it
+	Results         []LinkResult // one per checked URL, sorted by URL
+}
+
+// LinkResult is the outcome of checking one URL.
+type LinkResult struct {
+	URL        string `json:"url"`
+	Status     int    `json:"status"`          // final status code after redirects; 0 when no response arrived
+	Redirected bool   `json:"redirected"`      // the final URL differs from URL
+	Broken     bool   `json:"broken"`          // counted as inaccessible
+	Disallowed bool   `json:"disallowed"`      // robots.txt forbids fetching it, so it was not checked
+	Error      string `json:"error,omitempty"` // transport error when no response arrived
+}
+
+// fetchedPage holds the decoded body of a fetched page along with its transfer details.
+type fetchedPage struct {
+	Body            []byte
+	TransferSize    int    // bytes received on the wire, before decompression
+	ContentEncoding string // lower-cased Content-Encoding header
+	RedirectChain   []RedirectHop
+	RedirectsCapped bool
+	TTFB            time.Duration // time to the first response byte of the final hop
+	RedirectTime    time.Duration // spent on redirect hops before the final page
+	FinalFetch      time.Duration // final page, from its request to the end of the body
+}
+
+// analyzeOptions holds the optional, per-request analysis toggles.
+type analyzeOptions struct {
+	CheckImages  bool          // check <img src> URLs for accessibility (extra outbound requests)
+	FetchTitles  bool          // fetch the <title> of internal pages (extra outbound requests)
+	LinkTimeout  time.Duration // per-link check timeout override; 0 uses -per-request-timeout
+	LinkRetries  int           // retries of a transiently failed link check; 0 uses linkCheckRetries, negative disables them
+	FetchTimeout time.Duration // whole page fetch timeout override; 0 uses -fetch-timeout
+	BodyLimit    int64         // body bytes fetch reads; 0 uses -max-body-size
+	PrefixOnly   bool          // read just the first BodyLimit bytes of bigger bodies instead of failing
+	MaxLinks     int           // link check cap override; 0 uses -max-links
+	Workers      int           // concurrent link/image checks override; 0 uses -link-workers
+	Render       bool          // analyze the DOM rendered by headless Chrome instead of the raw HTML
+	Screenshot   bool          // capture a thumbnail of the rendered page (implies Render)
+	NoCache      bool          // send Cache-Control/Pragma: no-cache on the page fetch
+	BodyHash     bool          // include the SHA-256 of the fetched body in the result
+	MaxRedirects int           // redirect hop limit override; 0 uses maxRedirects
+	CompareAMP   bool          // also analyze the page's AMP (or canonical) counterpart and compare
+	HeadersOnly  bool          // audit response headers only; the body is neither read nor parsed
+	SkipSelf     bool          // leave self links (and fragment-only ones, always skipped) out of every count and check
+	MetaRefresh  bool          // follow a zero-delay <meta http-equiv="refresh"> on the fetched page, once
+	IgnoreRobots bool          // check links without consulting robots.txt
+	Pasted       bool          // analyze the pasted html= form value with the URL as its base instead of fetching
+	UserAgent    string        // User-Agent override; empty uses -user-agent
+	// AnalyzeErrors analyzes the body of a page answering 4xx/5xx instead of failing.
+	AnalyzeErrors bool
+	// Quick reads only the start of the body and reports its title, HTML version and
+	// headings; nothing else is extracted and no links are checked.
+	Quick bool
+	// SkipLinkChecks skips link and image checks; set internally for secondary pages.
+	SkipLinkChecks bool
+
+	cfg *settings // server or Analyzer settings the defaults come from; nil uses defaultSettings
+}
+
+// MisleadingLinks counts anchors whose visible text is a URL on a different host than the href.
+type MisleadingLinks struct {
+	Count   int              `json:"count"`
+	Samples []MisleadingLink `json:"samples"` // first few offenders
+}
+
+// MisleadingLink is an anchor whose text names a different host than its destination.
+type MisleadingLink struct {
+	Text string `json:"text"`
+	Href string `json:"href"`
+}
+
+// AMPComparison holds the analysis of a page's AMP/canonical counterpart and how it
+// differs from the page itself.
+type AMPComparison struct {
+	URL           string        `json:"url"`
+	Error         string        `json:"error,omitempty"` // the counterpart could not be fetched or parsed
+	Title         string        `json:"title"`
+	TitleMatches  bool          `json:"titleMatches"`
+	Headings      HeadingCounts `json:"headings"`
+	HeadingsMatch bool          `json:"headingsMatch"`
+	InternalLinks int           `json:"internalLinks"`
+	ExternalLinks int           `json:"externalLinks"`
+	LinksMatch    bool          `json:"linksMatch"` // same internal and external link counts
+}
+
+// StructuredData summarizes the structured-data markup on a page.
+type StructuredData struct {
+	JSONLD         int      `json:"jsonLD"`         // <script type="application/ld+json"> blocks
+	Microdata      int      `json:"microdata"`      // elements with itemscope
+	RDFa           int      `json:"rdfa"`           // elements with typeof
+	RDFaProperties int      `json:"rdfaProperties"` // non-<meta> elements with property
+	Types          []string `json:"types"`          // distinct declared types across all formats
+}
+
+// LoadingCounts splits elements by their loading attribute.
+type LoadingCounts struct {
+	Lazy  int `json:"lazy"`  // loading="lazy"
+	Eager int `json:"eager"` // loading="eager", or no (or an unknown) loading attribute
+}
+
+// PageMeta holds the tags search results and social previews are built from.
+type PageMeta struct {
+	Description   string `json:"description"`   // <meta name="description">
+	OGTitle       string `json:"ogTitle"`       // og:title
+	OGDescription string `json:"ogDescription"` // og:description
+	OGImage       string `json:"ogImage"`       // og:image, resolved against the page URL
+}
+
+// MetaIssue is a meta name or property declared more than once.
+type MetaIssue struct {
+	Name        string   `json:"name"`        // lower-cased name or property
+	Count       int      `json:"count"`       // declarations
+	Conflicting bool     `json:"conflicting"` // the declarations disagree on content (ignoring case)
+	Values      []string `json:"values"`      // content of each declaration, in document order
+}
+
+// TagCount is one TagHistogram entry.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// RoleIssues summarizes role attributes that are unknown or deprecated WAI-ARIA roles.
+type RoleIssues struct {
+	Invalid    int      `json:"invalid"`    // elements with an unknown (or empty) role
+	Deprecated int      `json:"deprecated"` // elements using a deprecated role
+	Samples    []string `json:"samples"`    // first offending role values
 }
 
 // link represents a hyperlink found on the page, along with whether it's internal or external.
 type link struct {
 	URL        *url.URL
 	IsInternal bool
+	IsDownload bool // <a download>; skipped by link checks
+	IsAbsolute bool // the href named a scheme or host rather than a relative path
 }
@@ -0,0 +1,89 @@
+package webanalyzer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"sync"
+)
+
+// analysisHistory keeps the most recent analyses of a server in memory, by ID, so their
+// links can be re-checked without fetching and parsing the page again. It is safe for
+// concurrent use; the oldest entry is evicted once limit is reached.
+type analysisHistory struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[string]*pageData
+	order   []string // IDs, oldest first
+}
+
+func newAnalysisHistory(limit int) *analysisHistory {
+	return &analysisHistory{limit: limit, entries: make(map[string]*pageData)}
+}
+
+// add stores pd under a new random ID and returns the ID.
+func (h *analysisHistory) add(pd *pageData) string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.order) >= h.limit {
+		delete(h.entries, h.order[0])
+		h.order = h.order[1:]
+	}
+	h.entries[id] = pd
+	h.order = append(h.order, id)
+	return id
+}
+
+// get returns the analysis stored under id.
+func (h *analysisHistory) get(id string) (*pageData, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pd, ok := h.entries[id]
+	return pd, ok
+}
+
+// replace swaps the analysis stored under id, if it is still present.
+func (h *analysisHistory) replace(id string, pd *pageData) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.entries[id]; ok {
+		h.entries[id] = pd
+	}
+}
+
+// recheckLinks re-runs the link checks of a stored analysis on its extracted links and
+// returns a copy with only the link-check fields updated. Image checks are not repeated.
+func recheckLinks(ctx context.Context, pd *pageData) *pageData {
+	if !pd.Options.IgnoreRobots {
+		ctx = withRobotsCache(ctx, pd.Options.settings().robots)
+	}
+	res := *pd.Result
+	sum := checkLinks(ctx, res.links, pd.Options)
+	res.InaccessibleLinks = sum.Inaccessible
+	res.CheckedLinks = sum.Checked
+	res.RedirectingLinks = sum.Redirecting
+	res.RedirectSamples = sum.RedirectSamples
+	res.LinkResults = sum.Results
+	res.RobotsDisallowedLinks = sum.Disallowed
+	// the new link lists are capped afresh; the other counts of dropped entries still hold
+	res.Omitted = maps.Clone(res.Omitted)
+	delete(res.Omitted, "linkResults")
+	delete(res.Omitted, "redirectSamples")
+	res.capLists(pd.Options.settings().MaxListItems)
+	// the image checks are not repeated, but their share of the totals still applies
+	res.LinkChecksDegraded = sum.Degraded || res.imageChecks.Degraded
+	res.ChecksSkipped = sum.Skipped + res.imageChecks.Skipped
+	res.ChecksTimedOut = sum.TimedOut + res.imageChecks.TimedOut
+	res.BudgetExceeded = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	res.RequestBudgetHit = budgetFrom(ctx).wasExhausted()
+
+	updated := *pd
+	updated.Result = &res
+	return &updated
+}
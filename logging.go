@@ -0,0 +1,45 @@
+package webanalyzer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// requestLog collects what handlers learn about a request for handlerMiddleware's log line.
+type requestLog struct {
+	mu  sync.Mutex
+	url string // URL submitted for analysis, if any
+}
+
+type requestLogKey struct{}
+
+// logAnalyzedURL notes the URL a request asked to analyze for its log line. It does nothing
+// outside handlerMiddleware.
+func logAnalyzedURL(ctx context.Context, u string) {
+	if rl, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		rl.mu.Lock()
+		rl.url = u
+		rl.mu.Unlock()
+	}
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
@@ -1,14 +1,47 @@
-package main
+package webanalyzer
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/time/rate"
 )
 
 // --- HTML Detections -----------------------------------------------------
@@ -86,6 +119,117 @@ func TestCountHeadings_IncludesARIA(t *testing.T) {
 	}
 }
 
+func TestCountEmptyHeadings(t *testing.T) {
+	html := `
+	<!doctype html><html><body>
+	<h1>Title</h1>
+	<h2></h2><h2>Section</h2><h2>  <span> </span> </h2>
+	<div role="heading" aria-level=" 3 "></div>
+	<div role="heading" aria-level="4">Named</div>
+	</body></html>`
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(html))
+	got := countEmptyHeadings(doc.Selection)
+
+	want := HeadingCounts{1: 0, 2: 2, 3: 1, 4: 0, 5: 0, 6: 0}
+	if !maps.Equal(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestAnalyze_MainHeadings(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	<header><h1>Site</h1></header>
+	<nav><h2>Menu</h2></nav>
+	<main>
+	  <h1>Article</h1><h2>Part</h2>
+	  <div role="heading" aria-level="3">ARIA</div>
+	</main>
+	<footer><h2>Footer</h2></footer>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if !res.HasMainLandmark {
+		t.Fatalf("expected main landmark")
+	}
+	wantAll := map[int]int{1: 2, 2: 3, 3: 1}
+	wantMain := map[int]int{1: 1, 2: 1, 3: 1}
+	for lvl := 1; lvl <= 6; lvl++ {
+		if res.Headings[lvl] != wantAll[lvl] {
+			t.Errorf("h%d all: want %d got %d", lvl, wantAll[lvl], res.Headings[lvl])
+		}
+		if res.MainHeadings[lvl] != wantMain[lvl] {
+			t.Errorf("h%d main: want %d got %d", lvl, wantMain[lvl], res.MainHeadings[lvl])
+		}
+	}
+}
+
+// --- Title vs h1 -----------------------------------------------------------------
+func TestAnalyze_TitleMatchesH1(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	cases := []struct {
+		name, html string
+		want       bool
+	}{
+		{"identical", `<title>Blue Widgets</title><body><h1>Blue Widgets</h1></body>`, true},
+		{"different", `<title>Blue Widgets | Shop</title><body><h1>Our range of widgets</h1></body>`, false},
+		{"whitespace and case", `<title>  Blue   Widgets </title><body><h1>blue
+		  widgets</h1></body>`, true},
+		{"main h1 preferred", `<title>Docs</title><body><header><h1>Docs</h1></header><main><h1>Getting started</h1></main></body>`, false},
+		{"no h1", `<title>Docs</title><body><p>x</p></body>`, false},
+	}
+	for _, tc := range cases {
+		res, err := analyzeFromHTML(base, "<!doctype html>"+tc.html)
+		if err != nil {
+			t.Fatalf("%s: analyze error: %v", tc.name, err)
+		}
+		if res.TitleMatchesH1 != tc.want {
+			t.Errorf("%s: TitleMatchesH1 = %v, want %v (h1 %q)", tc.name, res.TitleMatchesH1, tc.want, res.H1)
+		}
+	}
+}
+
+// --- Indexability -----------------------------------------------------------------
+func TestAnalyze_IndexableMeta(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	res, err := analyzeFromHTML(base, `<!doctype html><head><meta name="robots" content="noindex,nofollow"></head><body></body>`)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.Indexable || !strings.Contains(res.RobotsDirective, "noindex,nofollow") {
+		t.Fatalf("want noindex from meta, got %v %q", res.Indexable, res.RobotsDirective)
+	}
+
+	res, _ = analyzeFromHTML(base, `<!doctype html><head><meta name="robots" content="index, follow"></head><body></body>`)
+	if !res.Indexable {
+		t.Fatalf("want indexable page")
+	}
+}
+
+func TestHandleAnalyzeJSON_IndexableHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Robots-Tag", "googlebot: noindex")
+		_, _ = w.Write([]byte(`<!doctype html><title>T</title>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=indexable,robotsDirective&u="+url.QueryEscape(srv.URL), nil))
+	var got struct {
+		Indexable       bool
+		RobotsDirective string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v (%s)", err, rec.Body)
+	}
+	if got.Indexable || got.RobotsDirective != "X-Robots-Tag: googlebot: noindex" {
+		t.Fatalf("want noindex from header, got %+v", got)
+	}
+}
+
 // --- URL normalization & sameHost -------------------------------------------
 func TestNormalizeURL_Errors(t *testing.T) {
 	bad := []string{"://bad", "ftp://example.com", "http://"}
@@ -135,6 +279,49 @@ func TestAnalyze_LoginDetectionByNameOnly(t *testing.T) {
 	}
 }
 
+func TestAnalyze_LoginDetectionFormCap(t *testing.T) {
+	opts := analyzeOptions{cfg: testSettings(t, func(c *Config) { c.MaxForms = 10 })}
+
+	base, _ := normalizeURL("https://example.com")
+	many := strings.Repeat(`<form><input name="q"></form>`, 500)
+
+	res, err := analyze(t.Context(), base, []byte(`<!doctype html><body>`+many+`<form><input type="password"></form></body>`), opts)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.HasLogin || !res.FormsTruncated || res.FormsExamined != 10 {
+		t.Fatalf("want truncation at 10 forms without login, got login=%v examined=%d truncated=%v",
+			res.HasLogin, res.FormsExamined, res.FormsTruncated)
+	}
+
+	early := `<form><input name="q"></form><form><input type="password"></form>`
+	res, _ = analyze(t.Context(), base, []byte(`<!doctype html><body>`+early+many+`</body>`), opts)
+	if !res.HasLogin || res.FormsTruncated || res.FormsExamined != 2 {
+		t.Fatalf("want login found at the 2nd form, got login=%v examined=%d truncated=%v",
+			res.HasLogin, res.FormsExamined, res.FormsTruncated)
+	}
+}
+
+func TestAnalyze_FormsWithoutCSRF(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <form method="post" action="/a"><input type="hidden" name="csrf_token" value="x"><input name="q"></form>
+	  <form method="POST" action="/b"><input type="HIDDEN" name="authenticity_token" value="x"></form>
+	  <form method="post" action="/c"><input name="comment"><input type="hidden" name="id" value="1"></form>
+	  <form method="post" action="/d"><input type="text" name="csrf"></form>
+	  <form action="/search"><input name="q"></form>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	// /c has no token and /d's token field isn't hidden; the GET form is ignored
+	if res.FormsWithoutCSRF != 2 {
+		t.Fatalf("want 2 POST forms without a CSRF token, got %d", res.FormsWithoutCSRF)
+	}
+}
+
 // --- Internal vs External links ---------------------------------------------
 func TestAnalyze_InternalExternalCounts(t *testing.T) {
 	base, _ := normalizeURL("https://example.com")
@@ -157,45 +344,3175 @@ func TestAnalyze_InternalExternalCounts(t *testing.T) {
 	}
 }
 
-// --- Fetch + status via httptest (no internet) -------------------------------
-func TestFetch_StatusAndRedirect(t *testing.T) {
-	// final 200 server
-	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		_, _ = w.Write([]byte("<!doctype html><title>OK</title>"))
+func TestAnalyze_InternalAbsoluteRelative(t *testing.T) {
+	base, _ := normalizeURL("https://example.com/docs/")
+	html := `
+	<!doctype html><html><body>
+	  <a href="https://example.com/a">absolute</a>
+	  <a href="//example.com/b">scheme-relative</a>
+	  <a href="/c">root-relative</a>
+	  <a href="d">relative</a>
+	  <a href="../e?x=1">parent</a>
+	  <a href="https://other.org/">external</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.InternalLinks != 5 || res.ExternalLinks != 1 {
+		t.Fatalf("totals changed: internal=%d external=%d", res.InternalLinks, res.ExternalLinks)
+	}
+	if res.InternalAbsolute != 2 || res.InternalRelative != 3 {
+		t.Fatalf("want 2 absolute / 3 relative, got %d / %d", res.InternalAbsolute, res.InternalRelative)
+	}
+}
+
+func TestAnalyze_SkipsOverlongLinks(t *testing.T) {
+	opts := analyzeOptions{cfg: testSettings(t, func(c *Config) { c.MaxURLLength = 100 })}
+
+	base, _ := normalizeURL("https://example.com")
+	long := "/search?q=" + strings.Repeat("x", 200)
+	res, err := analyze(t.Context(), base, []byte(`<!doctype html><body><a href="/ok">ok</a><a href="`+long+`">long</a></body>`), opts)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.InternalLinks != 1 || res.LongLinksSkipped != 1 {
+		t.Fatalf("want 1 link kept and 1 skipped, got %d/%d", res.InternalLinks, res.LongLinksSkipped)
+	}
+}
+
+func TestAnalyze_MisleadingLinks(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <a href="https://evil.example.net/login">https://bank.example.org/login</a>
+	  <a href="https://www.bank.example.org/">bank.example.org</a>
+	  <a href="https://bank.example.org/">www.bank.example.org</a>
+	  <a href="https://other.example/">Read more</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := MisleadingLink{Text: "https://bank.example.org/login", Href: "https://evil.example.net/login"}
+	if res.MisleadingLinks.Count != 1 || len(res.MisleadingLinks.Samples) != 1 || res.MisleadingLinks.Samples[0] != want {
+		t.Fatalf("want 1 misleading link %+v, got %+v", want, res.MisleadingLinks)
+	}
+}
+
+func TestAnalyze_NewTabLinks(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <a href="https://other.org/" target="_blank" rel="noopener">one</a>
+	  <a href="/internal" target="_BLANK">two</a>
+	  <a href="/same" target="_self">same tab</a>
+	  <a href="/named" target="preview">named frame</a>
+	  <a href="/plain">plain</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.NewTabLinks != 2 {
+		t.Fatalf("want 2 new-tab links, got %d", res.NewTabLinks)
+	}
+}
+
+func TestAnalyze_InternalLinkDepthHistogram(t *testing.T) {
+	// link checks go to a local server so the test does no DNS lookups
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	base, _ := url.Parse(srv.URL + "/docs/")
+	html := `
+	<!doctype html><html><body>
+	  <a href="/">home</a>
+	  <a href="` + srv.URL + `">home again</a>
+	  <a href="/about/">about</a>
+	  <a href="/a/b/c?x=1">deep</a>
+	  <a href="guide/intro/setup">relative deep</a>
+	  <a href="http://127.0.0.2:1/x/y">external</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := map[int]int{0: 2, 1: 1, 3: 1, 4: 1}
+	if !maps.Equal(res.InternalLinkDepthHistogram, want) {
+		t.Fatalf("want %v, got %v", want, res.InternalLinkDepthHistogram)
+	}
+}
+
+func TestAnalyze_ExternalByTLD(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <a href="/about">internal</a>
+	  <a href="https://a.com/">a</a>
+	  <a href="https://b.com/x">b</a>
+	  <a href="https://gnu.org/">gnu</a>
+	  <a href="https://news.bbc.co.uk/">bbc</a>
+	  <a href="https://yandex.ru/">ya</a>
+	  <a href="http://192.0.2.1/">ip</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := map[string]int{".com": 2, ".org": 1, ".co.uk": 1, ".ru": 1, "(ip)": 1}
+	if !maps.Equal(res.ExternalByTLD, want) {
+		t.Fatalf("want %v, got %v", want, res.ExternalByTLD)
+	}
+}
+
+func TestAnalyze_InsecureExternalLinks(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <a href="http://example.com/internal">internal http</a>
+	  <a href="http://other.com/">external http</a>
+	  <a href="http://third.org/page">external http</a>
+	  <a href="https://secure.net/">external https</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.ExternalLinks != 3 || res.InsecureExternalLinks != 2 {
+		t.Fatalf("want external=3 insecure=2, got %d/%d", res.ExternalLinks, res.InsecureExternalLinks)
+	}
+}
+
+func TestAnalyze_DownloadLinks(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/files/") {
+			hits.Add(1)
+		}
 	}))
-	t.Cleanup(ok.Close)
+	t.Cleanup(srv.Close)
 
-	// redirecting server -> to ok.URL
-	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, ok.URL, http.StatusMovedPermanently) // 301
+	base, _ := normalizeURL(srv.URL)
+	html := `
+	<!doctype html><html><body>
+	  <a href="/files/report.pdf" download>report</a>
+	  <a href="/files/data.csv" download="export.csv">data</a>
+	  <a href="/page">page</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if len(res.DownloadLinks) != 2 || res.DownloadLinks[0] != srv.URL+"/files/report.pdf" {
+		t.Fatalf("unexpected download links: %v", res.DownloadLinks)
+	}
+	if res.InternalLinks != 3 || res.CheckedLinks != 1 || hits.Load() != 0 {
+		t.Fatalf("want 3 internal links, 1 checked, no download fetched; got %d/%d/%d", res.InternalLinks, res.CheckedLinks, hits.Load())
+	}
+}
+
+func TestAnalyze_SelfLinkCount(t *testing.T) {
+	base, _ := normalizeURL("https://example.com/page")
+	html := `
+	<!doctype html><html><body>
+	  <a href="/page">relative</a>
+	  <a href="https://EXAMPLE.com/page">absolute</a>
+	  <a href="/page#section">with fragment</a>
+	  <a href="/page?x=1">query differs</a>
+	  <a href="/other">other</a>
+	  <a href="#top">fragment only</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.SelfLinkCount != 4 {
+		t.Fatalf("want 4 self links, got %d", res.SelfLinkCount)
+	}
+
+	// after a redirect, links to the final URL are the self links, not those to the one asked for
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+			return
+		}
+		_, _ = w.Write([]byte(`<!doctype html><a href="/page">self</a><a href="/old">asked-for URL</a><a href="#x">anchor</a>`))
 	}))
-	t.Cleanup(redirect.Close)
+	t.Cleanup(srv.Close)
+	res2, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL+"/old")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res2.SelfLinkCount != 2 {
+		t.Fatalf("want 2 self links against the final URL, got %d", res2.SelfLinkCount)
+	}
+}
 
-	// Use our fetch to follow redirect
-	resp, body, err := fetch(t.Context(), redirect.URL)
+func TestAnalyze_SkipSelf(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid/page")
+	html := `
+	<!doctype html><html><body>
+	  <a href="/page">relative</a>
+	  <a href="/page#section" target="_blank">with fragment</a>
+	  <a href="/other">other</a>
+	  <a href="#top">fragment only</a>
+	  <a href="https://elsewhere.invalid/">external</a>
+	</body></html>`
+	for _, skip := range []bool{false, true} {
+		res, err := analyze(t.Context(), base, []byte(html), analyzeOptions{SkipSelf: skip, SkipLinkChecks: true})
+		if err != nil {
+			t.Fatalf("analyze error: %v", err)
+		}
+		wantInternal, wantNewTab, wantAnchors, wantSelf := 3, 1, 1, 3
+		if skip {
+			// the fragment-only link is a self link as well, so it is dropped from every count
+			wantInternal, wantNewTab, wantAnchors, wantSelf = 1, 0, 0, 2
+		}
+		if res.InternalLinks != wantInternal || res.NewTabLinks != wantNewTab || res.ExternalLinks != 1 || res.AnchorLinks != wantAnchors || res.SelfLinkCount != wantSelf || res.SelfLinksExcluded != skip {
+			t.Errorf("skip=%v: want %d internal, %d new-tab, 1 external, %d anchor, %d self links; got %d/%d/%d/%d/%d (excluded %v)",
+				skip, wantInternal, wantNewTab, wantAnchors, wantSelf, res.InternalLinks, res.NewTabLinks, res.ExternalLinks, res.AnchorLinks, res.SelfLinkCount, res.SelfLinksExcluded)
+		}
+	}
+}
+
+func TestAnalyze_DocumentLinks(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <a href="/files/report.pdf">Report</a>
+	  <a href="https://cdn.example.org/Annual.PDF?v=2">Annual</a>
+	  <a href="/files/letter.docx">Letter</a>
+	  <a href="/files/">Index</a>
+	  <a href="/post.html">Post</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
 	if err != nil {
-		t.Fatalf("fetch error: %v", err)
+		t.Fatalf("analyze error: %v", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
+	if want := map[string]int{"pdf": 2, "docx": 1}; !maps.Equal(res.DocumentLinks, want) {
+		t.Errorf("want document links %v, got %v", want, res.DocumentLinks)
+	}
+}
 
-	if resp.StatusCode != 200 {
-		t.Fatalf("expected final status 200, got %d", resp.StatusCode)
+func TestAnalyze_SkippedSchemes(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <a href="mailto:a@example.com">Mail</a>
+	  <a href="MAILTO:b@example.com">Mail</a>
+	  <a href="tel:+15551234">Call</a>
+	  <a href="sms:+15551234">Text</a>
+	  <a href="javascript:void(0)">JS</a>
+	  <a href="#top">Top</a>
+	  <a href="#">Top</a>
+	  <a href="/about">About</a>
+	  <a href="https://other.example.org/">Other</a>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := map[string]int{"mailto": 2, "tel": 1, "sms": 1, "javascript": 1}
+	if !maps.Equal(res.SkippedSchemes, want) {
+		t.Errorf("want skipped schemes %v, got %v", want, res.SkippedSchemes)
 	}
-	if !strings.Contains(string(body), "<title>OK</title>") {
-		t.Fatalf("unexpected body: %q", string(body))
+	if res.MailtoLinks != 2 || res.TelLinks != 1 || res.AnchorLinks != 2 {
+		t.Errorf("want 2 mailto, 1 tel and 2 anchor links, got %d, %d and %d", res.MailtoLinks, res.TelLinks, res.AnchorLinks)
+	}
+	if res.InternalLinks != 1 || res.ExternalLinks != 1 {
+		t.Errorf("want 1 internal and 1 external link, got %d and %d", res.InternalLinks, res.ExternalLinks)
 	}
 }
 
-// --- helpers ----------------------------------------------------------------
+// --- Unique domains ------------------------------------------------------------
+func TestAnalyze_UniqueDomains(t *testing.T) {
+	base, _ := normalizeURL("http://127.0.0.1:1")
+	html := `
+	<!doctype html><html><head>
+	  <script src="https://cdn.example.net/app.js"></script>
+	  <link rel="stylesheet" href="https://fonts.example.org/css">
+	  <link rel="preload" as="font" href="https://FONTS.example.org/font.woff2">
+	</head><body>
+	  <img src="https://img.example.com/a.png">
+	  <iframe src="https://video.example.io/embed/1"></iframe>
+	  <a href="https://cdn.example.net/other">same as script host</a>
+	  <a href="/local">own host</a>
+	  <img src="data:image/png;base64,AAAA">
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := []string{"cdn.example.net", "fonts.example.org", "img.example.com", "video.example.io"}
+	if !slices.Equal(res.UniqueDomains, want) {
+		t.Fatalf("want %v, got %v", want, res.UniqueDomains)
+	}
+}
 
-// analyzeFromHTML lets us bypass real fetch in unit tests.
-func analyzeFromHTML(base *url.URL, html string) (*analysisResult, error) {
-	_, _ = goquery.NewDocumentFromReader(strings.NewReader(html))
-	// emulate what analyze() does internally using the parsed document:
-	// We'll reuse the real 'analyze' by passing body bytes to it.
-	return analyze(tContext(), base, []byte(html))
+func TestAnalyze_ListCaps(t *testing.T) {
+	opts := analyzeOptions{cfg: testSettings(t, func(c *Config) { c.MaxListItems = 3 })}
+
+	base, _ := normalizeURL("https://example.com")
+	var b strings.Builder
+	b.WriteString("<!doctype html><body>")
+	for i := range 5 {
+		fmt.Fprintf(&b, `<a href="https://host%d.example/" download>f</a>`, i)
+	}
+	b.WriteString(`<a href="https://host0.example/again">dup host</a></body>`)
+	res, err := analyze(t.Context(), base, []byte(b.String()), opts)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if len(res.UniqueDomains) != 3 || len(res.DownloadLinks) != 3 {
+		t.Fatalf("want lists capped at 3, got %d domains / %d downloads", len(res.UniqueDomains), len(res.DownloadLinks))
+	}
+	if want := map[string]int{"uniqueDomains": 2, "downloadLinks": 2}; !maps.Equal(res.Omitted, want) {
+		t.Fatalf("want omitted %v, got %v", want, res.Omitted)
+	}
+	if res.ExternalLinks != 6 {
+		t.Fatalf("counts must not be capped, got %d external links", res.ExternalLinks)
+	}
+
+	b.Reset()
+	b.WriteString("<!doctype html><head>")
+	for i := range 5 {
+		fmt.Fprintf(&b, `<link rel="alternate" type="application/rss+xml" href="/feed%d.xml">`, i)
+		fmt.Fprintf(&b, `<link rel="sitemap" href="/sitemap%d.xml">`, i)
+		fmt.Fprintf(&b, `<link rel="canonical" href="/page%d">`, i)
+	}
+	b.WriteString("</head>")
+	if res, err = analyze(t.Context(), base, []byte(b.String()), opts); err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if len(res.Feeds) != 3 || len(res.Sitemaps) != 3 || res.Omitted["feeds"] != 2 || res.Omitted["sitemaps"] != 2 {
+		t.Fatalf("want feeds and sitemaps capped at 3 with 2 omitted each, got %d/%d, omitted %v", len(res.Feeds), len(res.Sitemaps), res.Omitted)
+	}
+	if len(res.Canonicals) != 3 || res.Omitted["canonicals"] != 2 || !res.CanonicalConflict || res.CanonicalTag != "https://example.com/page0" {
+		t.Fatalf("want canonicals capped at 3 with 2 omitted and the first kept as the tag, got %v, omitted %v", res.Canonicals, res.Omitted)
+	}
 }
 
-// tContext returns a background-like context for tests.
-func tContext() context.Context { return context.Background() }
+func TestAnalyze_ListCapsKeepBrokenLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/gone") {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	opts := analyzeOptions{LinkTimeout: time.Second, cfg: testSettings(t, func(c *Config) { c.MaxListItems = 3 })}
+
+	base, _ := normalizeURL(srv.URL)
+	var b strings.Builder
+	b.WriteString("<!doctype html><body>")
+	for i := range 2 {
+		fmt.Fprintf(&b, `<a href="/a-ok%d">ok</a>`, i)
+	}
+	for i := range 5 {
+		fmt.Fprintf(&b, `<a href="/gone%d">gone</a>`, i)
+	}
+	b.WriteString("</body>")
+	res, err := analyze(t.Context(), base, []byte(b.String()), opts)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.InaccessibleLinks != 5 || res.Omitted["linkResults"] != 4 {
+		t.Fatalf("want 5 broken links and 4 link results omitted, got %d, omitted %v", res.InaccessibleLinks, res.Omitted)
+	}
+	var got []string
+	for _, l := range res.LinkResults {
+		if !l.Broken {
+			t.Errorf("want only broken links kept, got %+v", l)
+		}
+		got = append(got, strings.TrimPrefix(l.URL, srv.URL))
+	}
+	if want := []string{"/gone0", "/gone1", "/gone2"}; !slices.Equal(got, want) {
+		t.Fatalf("want link results %v, got %v", want, got)
+	}
+}
+
+// --- Hidden elements ----------------------------------------------------------
+func TestAnalyze_HiddenElementCount(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <div hidden>attr</div>
+	  <div aria-hidden="true">aria</div>
+	  <div aria-hidden="false">visible aria</div>
+	  <div style="display: none">display</div>
+	  <div style="color:red; visibility:hidden">visibility</div>
+	  <div style="color:red">visible</div>
+	  <div hidden aria-hidden="true">counted once</div>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.HiddenElementCount != 5 {
+		t.Fatalf("want 5 hidden elements, got %d", res.HiddenElementCount)
+	}
+}
+
+// --- Tag histogram -----------------------------------------------------------------
+func TestAnalyze_TagHistogram(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `<!doctype html><html><head><title>T</title></head><body>` +
+		strings.Repeat(`<p><span>a</span><span>b</span></p>`, 4) +
+		strings.Repeat(`<a href="/x">x</a>`, 3) +
+		`<div><ul>` + strings.Repeat(`<li>i</li>`, 5) + `</ul></div>` + strings.Repeat(`<i>1</i>`, 5) +
+		`</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if len(res.TagHistogram) != maxTagHistogram {
+		t.Fatalf("want %d entries, got %d", maxTagHistogram, len(res.TagHistogram))
+	}
+	want := []TagCount{{"span", 8}, {"i", 5}, {"li", 5}, {"p", 4}, {"a", 3}}
+	if got := res.TagHistogram[:len(want)]; !slices.Equal(got, want) {
+		t.Fatalf("want top tags %v, got %v", want, got)
+	}
+}
+
+// --- Iframes ---------------------------------------------------------------------
+func TestAnalyze_IframesMissingTitle(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><body>
+	  <iframe src="about:blank" title="Store locator map"></iframe>
+	  <iframe src="about:blank"></iframe>
+	  <iframe src="about:blank" title="  "></iframe>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.IframeCount != 3 || res.IframesMissingTitle != 2 {
+		t.Fatalf("want 3 iframes, 2 untitled; got %d/%d", res.IframeCount, res.IframesMissingTitle)
+	}
+}
+
+// --- Image alt text ----------------------------------------------------------------
+func TestAnalyze_ImageAlt(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><body>
+	  <img src="/logo.png" alt="Company logo">
+	  <img src="/spacer.gif" alt="">
+	  <img src="/rule.png" alt="  ">
+	  <img src="/photo.jpg">
+	  <img src="/chart.png">
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.ImageCount != 5 || res.ImagesMissingAlt != 2 || res.ImagesEmptyAlt != 2 {
+		t.Fatalf("want 5 images, 2 missing alt, 2 empty alt; got %d, %d, %d", res.ImageCount, res.ImagesMissingAlt, res.ImagesEmptyAlt)
+	}
+}
+
+// --- Lazy loading -------------------------------------------------------------------
+func TestAnalyze_LazyLoading(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><body>
+	  <img src="/a.png" loading="lazy" alt="">
+	  <img src="/b.png" loading=" LAZY " alt="">
+	  <img src="/c.png" loading="eager" alt="">
+	  <img src="/d.png" alt="">
+	  <iframe src="about:blank" loading="lazy" title="x"></iframe>
+	  <iframe src="about:blank" title="y"></iframe>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if want := (LoadingCounts{Lazy: 2, Eager: 2}); res.ImageLoading != want {
+		t.Fatalf("want images %+v, got %+v", want, res.ImageLoading)
+	}
+	if want := (LoadingCounts{Lazy: 1, Eager: 1}); res.IframeLoading != want {
+		t.Fatalf("want iframes %+v, got %+v", want, res.IframeLoading)
+	}
+}
+
+// --- Subresource Integrity -------------------------------------------------------
+func TestAnalyze_ScriptsWithoutSRI(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><head>
+	  <script src="https://cdn.example.net/lib.js" integrity="sha384-abc" crossorigin="anonymous"></script>
+	  <script src="https://cdn.example.net/other.js"></script>
+	  <script src="//cdn.example.org/x.js" integrity=" "></script>
+	  <script src="/app.js"></script>
+	  <script>inline()</script>
+	</head><body></body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.ExternalScripts != 3 || res.ScriptsWithoutSRI != 2 {
+		t.Fatalf("want 3 external scripts, 2 without SRI; got %d, %d", res.ExternalScripts, res.ScriptsWithoutSRI)
+	}
+}
+
+// --- Tabindex ----------------------------------------------------------------------
+func TestAnalyze_Tabindex(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><body>
+	  <div tabindex="0">focusable</div>
+	  <div tabindex="-1">programmatic</div>
+	  <button tabindex="5">jumps ahead</button>
+	  <span tabindex="x">invalid</span>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.TabindexCount != 4 || res.PositiveTabindexCount != 1 {
+		t.Fatalf("want 4 with tabindex, 1 positive; got %d/%d", res.TabindexCount, res.PositiveTabindexCount)
+	}
+}
+
+// --- Landmark coverage -----------------------------------------------------------
+func TestAnalyze_InteractiveOutsideLandmark(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <header><a href="/">Home</a></header>
+	  <nav><a href="/a">A</a><a href="/b">B</a></nav>
+	  <main>
+	    <article><header><a href="/c">In article header</a></header></article>
+	    <form><input name="q"><input type="hidden" name="t"><button>Go</button></form>
+	  </main>
+	  <div role="navigation"><a href="/d">D</a></div>
+	  <div role="presentation"><button>Outside</button></div>
+	  <section><a href="/e">Unnamed section</a></section>
+	  <section aria-label="Promo"><a href="/f">Named section</a></section>
+	  <a href="/g">Loose</a>
+	  <select><option>1</option></select>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.InteractiveElements != 12 || res.InteractiveOutsideLandmark != 4 {
+		t.Fatalf("want 12 interactive elements, 4 outside landmarks; got %d and %d", res.InteractiveElements, res.InteractiveOutsideLandmark)
+	}
+}
+
+// --- Structured data ---------------------------------------------------------------
+func TestAnalyze_StructuredData(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><head>
+	  <meta property="og:title" content="Not RDFa for counting">
+	  <script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"Organization"}]}</script>
+	</head><body>
+	  <div itemscope itemtype="https://schema.org/Product">
+	    <span itemprop="name">Widget</span>
+	  </div>
+	  <div vocab="https://schema.org/" typeof="Person">
+	    <span property="name">Ada</span>
+	  </div>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	sd := res.StructuredData
+	if sd.JSONLD != 1 || sd.Microdata != 1 || sd.RDFa != 1 || sd.RDFaProperties != 1 {
+		t.Fatalf("want one item per format and one RDFa property, got %+v", sd)
+	}
+	want := []string{"Organization", "Person", "https://schema.org/Product"}
+	if !slices.Equal(sd.Types, want) {
+		t.Fatalf("want types %v, got %v", want, sd.Types)
+	}
+}
+
+// --- Theme color & color scheme ------------------------------------------------
+func TestAnalyze_ThemeColorAndColorScheme(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><head>
+	  <meta name="theme-color" content="#4285f4">
+	  <meta name="Color-Scheme" content=" light dark ">
+	</head><body></body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if !res.HasThemeColor || res.ThemeColor != "#4285f4" {
+		t.Errorf("theme-color: got %v %q", res.HasThemeColor, res.ThemeColor)
+	}
+	if !res.HasColorScheme || res.ColorScheme != "light dark" {
+		t.Errorf("color-scheme: got %v %q", res.HasColorScheme, res.ColorScheme)
+	}
+
+	res, _ = analyzeFromHTML(base, `<!doctype html><title>none</title>`)
+	if res.HasThemeColor || res.HasColorScheme {
+		t.Errorf("expected no theme-color/color-scheme on a bare page")
+	}
+}
+
+// --- Duplicate meta tags ------------------------------------------------------------
+func TestAnalyze_MetaIssues(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid")
+	html := `
+	<!doctype html><html><head>
+	  <meta name="description" content="Shoes and more">
+	  <meta name="Description" content="shoes and more ">
+	  <meta name="robots" content="index, follow">
+	  <meta name="robots" content="noindex">
+	  <meta property="og:image" content="/a.png">
+	  <meta property="og:image" content="/b.png">
+	  <meta name="theme-color" media="(prefers-color-scheme: light)" content="#fff">
+	  <meta name="theme-color" media="(prefers-color-scheme: dark)" content="#000">
+	  <meta name="viewport" content="width=device-width">
+	</head><body></body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := []MetaIssue{
+		{Name: "description", Count: 2, Values: []string{"Shoes and more", "shoes and more"}},
+		{Name: "robots", Count: 2, Conflicting: true, Values: []string{"index, follow", "noindex"}},
+	}
+	if !reflect.DeepEqual(res.MetaIssues, want) {
+		t.Fatalf("want %+v, got %+v", want, res.MetaIssues)
+	}
+}
+
+func TestAnalyze_CanonicalConflict(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid/shoes")
+	cases := []struct {
+		name, head string
+		want       []string
+		conflict   bool
+	}{
+		{"none", ``, nil, false},
+		{"same twice", `<link rel="canonical" href="/shoes"><link rel="canonical" href="https://example.invalid/shoes">`, []string{"https://example.invalid/shoes"}, false},
+		{"different", `<link rel="canonical" href="/shoes"><link rel="canonical" href="/shoes?page=2">`, []string{"https://example.invalid/shoes", "https://example.invalid/shoes?page=2"}, true},
+	}
+	for _, c := range cases {
+		res, err := analyze(t.Context(), base, []byte(`<!doctype html><html><head>`+c.head+`</head><body></body></html>`), analyzeOptions{SkipLinkChecks: true})
+		if err != nil {
+			t.Fatalf("%s: analyze error: %v", c.name, err)
+		}
+		if !slices.Equal(res.Canonicals, c.want) || res.CanonicalConflict != c.conflict {
+			t.Errorf("%s: want %v (conflict %v), got %v (conflict %v)", c.name, c.want, c.conflict, res.Canonicals, res.CanonicalConflict)
+		}
+	}
+}
+
+func TestAnalyze_CanonicalTag(t *testing.T) {
+	base, _ := normalizeURL("https://example.com/post")
+	cases := []struct {
+		name, head, want string
+		matches          bool
+	}{
+		{"self", `<link rel="canonical" href="/post#top">`, "https://example.com/post#top", true},
+		{"host case", `<link rel="canonical" href="https://EXAMPLE.com/post">`, "https://EXAMPLE.com/post", true},
+		{"elsewhere", `<link rel="canonical" href="https://example.com/post?page=2">`, "https://example.com/post?page=2", false},
+		{"none", ``, "", false},
+	}
+	for _, c := range cases {
+		res, err := analyzeFromHTML(base, "<!doctype html><html><head>"+c.head+"</head><body></body></html>")
+		if err != nil {
+			t.Fatalf("%s: analyze error: %v", c.name, err)
+		}
+		if res.CanonicalTag != c.want || res.CanonicalMatchesURL != c.matches {
+			t.Errorf("%s: want %q (matches %v), got %q (matches %v)", c.name, c.want, c.matches, res.CanonicalTag, res.CanonicalMatchesURL)
+		}
+	}
+}
+
+func TestAnalyze_MixedContent(t *testing.T) {
+	page := `<!doctype html><html><head>
+<link rel="stylesheet" href="http://cdn.example.com/site.css">
+<link rel="canonical" href="http://example.com/post">
+<link rel="icon" href="//example.com/favicon.ico">
+<script src="HTTP://cdn.example.com/app.js"></script>
+</head><body>
+<img src="http://img.example.com/a.png"><img src="http://img.example.com/a.png"><img src="/b.png">
+<iframe src="http://video.example.com/embed"></iframe>
+<a href="http://example.org/">not a resource</a>
+</body></html>`
+	want := []string{"http://cdn.example.com/site.css", "http://cdn.example.com/app.js", "http://img.example.com/a.png", "http://video.example.com/embed"}
+
+	secure, _ := normalizeURL("https://example.com/post")
+	res, err := analyze(t.Context(), secure, []byte(page), analyzeOptions{SkipLinkChecks: true})
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if !slices.Equal(res.MixedContent, want) || res.MixedContentCount != len(want) {
+		t.Fatalf("want mixed content %v, got %v (count %d)", want, res.MixedContent, res.MixedContentCount)
+	}
+
+	plain, _ := normalizeURL("http://example.com/post")
+	if res, err = analyze(t.Context(), plain, []byte(page), analyzeOptions{SkipLinkChecks: true}); err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.MixedContent != nil || res.MixedContentCount != 0 {
+		t.Fatalf("want no mixed content on an http page, got %v", res.MixedContent)
+	}
+	// served over https after a redirect
+	res.setMixedContent(secure, defaultMaxListItems)
+	if res.MixedContentCount != len(want) {
+		t.Fatalf("want mixed content once the final URL is https, got %d", res.MixedContentCount)
+	}
+}
+
+func TestAnalyze_PageMeta(t *testing.T) {
+	base, _ := normalizeURL("https://example.com/blog/post")
+	res, err := analyzeFromHTML(base, `<!doctype html><html><head>
+<meta name="description" content=" A post about things. ">
+<meta property="og:title" content="Things">
+<meta property="og:description" content="All about things">
+<meta property="og:image" content="img/cover.png">
+<meta property="og:image" content="img/second.png">
+</head><body></body></html>`)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := PageMeta{
+		Description:   "A post about things.",
+		OGTitle:       "Things",
+		OGDescription: "All about things",
+		OGImage:       "https://example.com/blog/img/cover.png",
+	}
+	if res.Meta != want {
+		t.Fatalf("want %+v, got %+v", want, res.Meta)
+	}
+
+	res, err = analyzeFromHTML(base, `<!doctype html><html><head><title>Bare</title></head><body></body></html>`)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.Meta != (PageMeta{}) {
+		t.Fatalf("want empty meta without the tags, got %+v", res.Meta)
+	}
+}
+
+func TestMetaRefreshTarget(t *testing.T) {
+	base, _ := url.Parse("https://example.com/dir/page")
+	cases := []struct{ meta, want string }{
+		{`<meta http-equiv="refresh" content="0;url=/next">`, "https://example.com/next"},
+		{`<meta http-equiv="Refresh" content="0; URL='other'">`, "https://example.com/dir/other"},
+		{`<meta http-equiv="refresh" content="0, https://elsewhere.example.org/">`, "https://elsewhere.example.org/"},
+		{`<meta http-equiv="refresh" content="5;url=/later">`, ""},
+		{`<meta http-equiv="refresh" content="0">`, ""},
+		{`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`, ""},
+		{`<meta name="refresh" content="0;url=/next">`, ""},
+	}
+	for _, c := range cases {
+		got := ""
+		if u := metaRefreshTarget([]byte("<html><head>"+c.meta+"</head></html>"), base); u != nil {
+			got = u.String()
+		}
+		if got != c.want {
+			t.Errorf("%s: want %q, got %q", c.meta, c.want, got)
+		}
+	}
+}
+
+func TestAnalyze_AnalyzeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`<!doctype html><title>Oops</title><h1>Server error</h1><h2>Try again</h2><h2>Contact us</h2>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL); !errors.Is(err, errNonOKStatus) {
+		t.Fatalf("want the status error by default, got %v", err)
+	}
+
+	res, err := New(Options{SkipLinkChecks: true, AnalyzeErrors: true}).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("want the error page analyzed, got %v", err)
+	}
+	if res.HTTPStatus != http.StatusInternalServerError || res.Title != "Oops" || res.Headings[1] != 1 || res.Headings[2] != 2 {
+		t.Fatalf("want status 500 with the page's title and headings, got %d, %q, %v", res.HTTPStatus, res.Title, res.Headings)
+	}
+	if !strings.Contains(res.StatusError, "500") {
+		t.Fatalf("want the status error reported, got %q", res.StatusError)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(url.Values{"u": {srv.URL}, "analyze_errors": {"1"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	pd, status := testServer(t, nil).runAnalysis(t.Context(), req)
+	if status != http.StatusOK || pd.Result == nil || pd.HTTPStatus != http.StatusInternalServerError {
+		t.Fatalf("want analyze_errors=1 to analyze the error page, got %d: %s", status, pd.Error)
+	}
+}
+
+func TestAnalyze_MetaRefreshResolvesAgainstTarget(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte(`<meta http-equiv="refresh" content="0;url=/docs/start">`))
+		case "/docs/start":
+			_, _ = w.Write([]byte(`<!doctype html><title>Start</title><a href="next">Next</a><a href="start#intro">Self</a>`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{MetaRefresh: true}).Analyze(t.Context(), srv.URL+"/")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.URL != srv.URL+"/docs/start" || res.SelfLinkCount != 1 {
+		t.Fatalf("want the target analyzed with its self link counted, got %s with %d self links", res.URL, res.SelfLinkCount)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Contains(paths, "/docs/next") || slices.Contains(paths, "/next") {
+		t.Fatalf("want href=next resolved against /docs/start, got requests %v", paths)
+	}
+}
+
+func TestAnalyze_QuickMode(t *testing.T) {
+	var linkHit atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/other" {
+			linkHit.Store(true)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<!doctype html><title>Big page</title><h1>Top</h1><h2>Intro</h2><a href="/other">other</a>`))
+		// past the quick read limit: neither read nor counted
+		_, _ = w.Write(bytes.Repeat([]byte("<p>filler</p>"), 2*quickBodyLimit/len("<p>filler</p>")))
+		_, _ = w.Write([]byte(`<h2>Far below</h2>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{Quick: true}).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if !res.Quick || res.Title != "Big page" || res.HTMLVersion != "HTML5" {
+		t.Fatalf("want a quick result with title and version, got quick=%v %q %q", res.Quick, res.Title, res.HTMLVersion)
+	}
+	if res.Headings[1] != 1 || res.Headings[2] != 1 {
+		t.Fatalf("want headings from the first %d bytes only, got %v", quickBodyLimit, res.Headings)
+	}
+	if res.InternalLinks != 0 || res.CheckedLinks != 0 || linkHit.Load() {
+		t.Fatalf("want no links counted or checked, got %d internal, %d checked, hit=%v", res.InternalLinks, res.CheckedLinks, linkHit.Load())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(url.Values{"u": {srv.URL}, "quick": {"1"}, "mode": {"headers-only"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, status := testServer(t, nil).runAnalysis(t.Context(), req); status != http.StatusBadRequest {
+		t.Fatalf("want quick with headers-only refused, got %d", status)
+	}
+}
+
+func TestAnalyze_FollowsMetaRefresh(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/bounce":
+			_, _ = w.Write([]byte(`<html><head><meta http-equiv="refresh" content="0;url=/real"></head></html>`))
+		case "/real":
+			_, _ = w.Write([]byte(`<!doctype html><title>Real</title><meta http-equiv="refresh" content="0;url=/bounce">`))
+		case "/self":
+			_, _ = w.Write([]byte(`<title>Self</title><meta http-equiv="refresh" content="0;url=/self#x">`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL+"/bounce")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.Title != "(no title)" || res.MetaRefreshFrom != "" {
+		t.Fatalf("want the bounce page itself without the option, got %q (from %q)", res.Title, res.MetaRefreshFrom)
+	}
+
+	hits.Store(0)
+	a := New(Options{SkipLinkChecks: true, MetaRefresh: true})
+	res, err = a.Analyze(t.Context(), srv.URL+"/bounce")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.Title != "Real" || res.URL != srv.URL+"/real" || res.MetaRefreshFrom != srv.URL+"/bounce" || hits.Load() != 2 {
+		t.Fatalf("want one hop to /real, got %q at %s from %q after %d requests", res.Title, res.URL, res.MetaRefreshFrom, hits.Load())
+	}
+
+	res, err = a.Analyze(t.Context(), srv.URL+"/self")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.Title != "Self" || res.MetaRefreshFrom != "" {
+		t.Fatalf("want a refresh to the page itself ignored, got %q from %q", res.Title, res.MetaRefreshFrom)
+	}
+}
+
+func TestFetch_CanonicalComparedToFinalURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<!doctype html><html><head><link rel="canonical" href="/new"></head><body></body></html>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL+"/old")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if !res.CanonicalMatchesURL {
+		t.Fatalf("want the canonical to match the redirect target, got %q", res.CanonicalTag)
+	}
+}
+
+func TestAnalyze_FeedsAndSitemaps(t *testing.T) {
+	base, _ := normalizeURL("https://example.invalid/blog/")
+	html := `
+	<!doctype html><html><head>
+	  <link rel="alternate" type="application/rss+xml" title="RSS" href="feed.xml">
+	  <link rel="alternate" type="application/atom+xml; charset=utf-8" href="https://example.invalid/atom">
+	  <link rel="alternate" type="application/rss+xml" href="/blog/feed.xml">
+	  <link rel="alternate" hreflang="de" href="/de/">
+	  <link rel="sitemap" type="application/xml" href="/sitemap.xml">
+	</head><body></body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if want := []string{"https://example.invalid/blog/feed.xml", "https://example.invalid/atom"}; !slices.Equal(res.Feeds, want) {
+		t.Errorf("want feeds %v, got %v", want, res.Feeds)
+	}
+	if want := []string{"https://example.invalid/sitemap.xml"}; !slices.Equal(res.Sitemaps, want) {
+		t.Errorf("want sitemaps %v, got %v", want, res.Sitemaps)
+	}
+}
+
+// --- Tech fingerprinting ------------------------------------------------------------
+func TestAnalyze_DetectedTech(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	wordpress := `
+	<!doctype html><html><head>
+	  <meta name="generator" content="WordPress 6.5">
+	  <link rel="stylesheet" href="/wp-content/themes/twenty/style.css">
+	</head><body><p>Blog</p></body></html>`
+	res, err := analyzeFromHTML(base, wordpress)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if want := []string{"WordPress"}; !slices.Equal(res.DetectedTech, want) {
+		t.Fatalf("want %v, got %v", want, res.DetectedTech)
+	}
+
+	next := `
+	<!doctype html><html><head>
+	  <script src="/_next/static/chunks/main.js" defer></script>
+	</head><body><div id="__next"><h1>App</h1></div>
+	  <script id="__NEXT_DATA__" type="application/json">{"props":{}}</script>
+	</body></html>`
+	res, _ = analyzeFromHTML(base, next)
+	if want := []string{"Next.js", "React"}; !slices.Equal(res.DetectedTech, want) {
+		t.Fatalf("want %v, got %v", want, res.DetectedTech)
+	}
+
+	h := http.Header{}
+	h.Set("X-Generator", "Drupal 10 (https://www.drupal.org)")
+	if got := headerTech([]string{"React"}, h); !slices.Equal(got, []string{"Drupal", "React"}) {
+		t.Fatalf("want Drupal from headers, got %v", got)
+	}
+}
+
+// --- Consent banners ---------------------------------------------------------------
+func TestAnalyze_ConsentBanner(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	onetrust := `
+	<!doctype html><html><head>
+	  <script src="https://cdn.cookielaw.org/scripttemplates/otSDKStub.js" data-domain-script="abc"></script>
+	</head><body><p>x</p></body></html>`
+	res, err := analyzeFromHTML(base, onetrust)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if !res.HasConsentBanner || res.ConsentVendor != "OneTrust" {
+		t.Fatalf("want OneTrust banner, got %v/%q", res.HasConsentBanner, res.ConsentVendor)
+	}
+
+	res, _ = analyzeFromHTML(base, `<!doctype html><body><div class="site-cookie-consent">We use cookies</div></body>`)
+	if !res.HasConsentBanner || res.ConsentVendor != "" {
+		t.Fatalf("want generic banner without vendor, got %v/%q", res.HasConsentBanner, res.ConsentVendor)
+	}
+
+	res, _ = analyzeFromHTML(base, `<!doctype html><body><p>No banner</p></body>`)
+	if res.HasConsentBanner {
+		t.Fatalf("want no banner")
+	}
+}
+
+// --- Noscript --------------------------------------------------------------------
+func TestAnalyze_Noscript(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><head>
+	  <noscript><link rel="stylesheet" href="/noscript.css"></noscript>
+	</head><body>
+	  <noscript><img src="https://tracker.example/pixel.gif"></noscript>
+	  <noscript><p>JavaScript is off. <a href="/basic">Use the basic site</a></p></noscript>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.NoscriptCount != 3 || !res.HasNoscriptFallback {
+		t.Fatalf("want 3 noscript blocks with fallback, got %d/%v", res.NoscriptCount, res.HasNoscriptFallback)
+	}
+
+	res, _ = analyzeFromHTML(base, `<!doctype html><body><noscript><img src="https://tracker.example/p.gif"></noscript></body>`)
+	if res.NoscriptCount != 1 || res.HasNoscriptFallback {
+		t.Fatalf("tracking pixel is not a fallback, got %d/%v", res.NoscriptCount, res.HasNoscriptFallback)
+	}
+}
+
+// --- Inline event handlers ---------------------------------------------------
+func TestAnalyze_InlineEventHandlers(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body onload="init()">
+	  <button onclick="a()">a</button>
+	  <button onClick="b()" onmouseover="c()">b</button>
+	  <img src="data:," onerror="d()">
+	  <div>plain</div>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.InlineEventHandlerCount != 4 {
+		t.Fatalf("want 4 elements with handlers, got %d", res.InlineEventHandlerCount)
+	}
+	want := map[string]int{"onload": 1, "onclick": 2, "onmouseover": 1, "onerror": 1}
+	for name, n := range want {
+		if res.InlineEventHandlers[name] != n {
+			t.Errorf("%s: want %d got %d", name, n, res.InlineEventHandlers[name])
+		}
+	}
+}
+
+// --- ARIA roles --------------------------------------------------------------
+func TestAnalyze_InvalidRoles(t *testing.T) {
+	base, _ := normalizeURL("https://example.com")
+	html := `
+	<!doctype html><html><body>
+	  <nav role="navigation">ok</nav>
+	  <div role="button link">fallback list</div>
+	  <div role="bogus">bad</div>
+	  <ul role="directory">deprecated</ul>
+	</body></html>`
+	res, err := analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	got := res.InvalidRoles
+	if got.Invalid != 1 || got.Deprecated != 1 {
+		t.Fatalf("want invalid=1 deprecated=1, got %d/%d", got.Invalid, got.Deprecated)
+	}
+	if len(got.Samples) != 2 || got.Samples[0] != "bogus" {
+		t.Fatalf("unexpected samples: %v", got.Samples)
+	}
+}
+
+// --- Image checks --------------------------------------------------------------
+func TestAnalyze_BrokenImages(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok.png" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	base, _ := normalizeURL(srv.URL)
+	html := `<!doctype html><html><body><img src="/ok.png"><img src="/missing.png"><img src="/ok.png"></body></html>`
+
+	res, err := analyze(t.Context(), base, []byte(html), analyzeOptions{CheckImages: true})
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.CheckedImages != 2 || res.BrokenImages != 1 {
+		t.Fatalf("want checked=2 broken=1, got %d/%d", res.CheckedImages, res.BrokenImages)
+	}
+
+	// disabled by default: no image requests
+	res, err = analyzeFromHTML(base, html)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.CheckedImages != 0 || res.BrokenImages != 0 {
+		t.Fatalf("want no image checks when disabled, got %d/%d", res.CheckedImages, res.BrokenImages)
+	}
+}
+
+// --- Link timeout override ---------------------------------------------------
+func TestCheckLinks_TimeoutOverride(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(slow.Close)
+
+	u, _ := url.Parse(slow.URL + "/slow")
+	links := []link{{URL: u, IsInternal: true}}
+
+	if bad := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: 50 * time.Millisecond}).Inaccessible; bad != 1 {
+		t.Fatalf("want slow link inaccessible under a short timeout, got %d broken", bad)
+	}
+	if bad := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: 2 * time.Second}).Inaccessible; bad != 0 {
+		t.Fatalf("want slow link accessible with raised timeout, got %d broken", bad)
+	}
+}
+
+func TestCheckLinks_TimeoutOverrideBeatsHeaderTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(600 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(slow.Close)
+	cfg := testSettings(t, func(c *Config) { c.HeaderTimeout = 200 * time.Millisecond })
+
+	u, _ := url.Parse(slow.URL + "/slow")
+	links := []link{{URL: u, IsInternal: true}}
+	if bad := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: 3 * time.Second, LinkRetries: -1, cfg: cfg}).Inaccessible; bad != 0 {
+		t.Fatalf("want the link timeout to override the shorter header timeout, got %d broken", bad)
+	}
+}
+
+func TestCheckLinks_RetriesTransientFailures(t *testing.T) {
+	var mu sync.Mutex
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky" && n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var links []link
+	for _, p := range []string{"/flaky", "/missing", "/down"} {
+		u, _ := url.Parse(srv.URL + p)
+		links = append(links, link{URL: u})
+	}
+	sum := checkLinks(t.Context(), links, analyzeOptions{})
+	if sum.Checked != 3 || sum.Inaccessible != 2 {
+		t.Fatalf("want the flaky link to pass on retry and 2 broken, got %d checked, %d broken", sum.Checked, sum.Inaccessible)
+	}
+	mu.Lock()
+	if hits["/flaky"] != 2 || hits["/missing"] != 1 || hits["/down"] != 1+linkCheckRetries {
+		t.Errorf("want 2 tries of /flaky, 1 of /missing and %d of /down, got %v", 1+linkCheckRetries, hits)
+	}
+	clear(hits)
+	mu.Unlock()
+
+	if bad := checkLinks(t.Context(), links[:1], analyzeOptions{LinkRetries: -1}).Inaccessible; bad != 1 {
+		t.Fatalf("want the flaky link broken without retries, got %d broken", bad)
+	}
+
+	// a dying budget ends the backoff instead of waiting it out
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	sum = checkLinks(ctx, links[2:], analyzeOptions{LinkRetries: 5})
+	if took := time.Since(start); took > time.Second || sum.Inaccessible != 1 {
+		t.Fatalf("want a prompt broken result near the budget, took %s with %+v", took, sum)
+	}
+}
+
+func TestParseAnalyzeOptions_LinkTimeout(t *testing.T) {
+	srv := testServer(t, nil)
+	opts, err := srv.parseAnalyzeOptions(url.Values{"link_timeout": {"20"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := opts.linkTimeout(); got != 20*time.Second {
+		t.Errorf("want 20s, got %s", got)
+	}
+
+	opts, _ = srv.parseAnalyzeOptions(url.Values{"link_timeout": {"3600"}})
+	if got := opts.linkTimeout(); got != srv.cfg.TotalBudget {
+		t.Errorf("want override capped at budget %s, got %s", srv.cfg.TotalBudget, got)
+	}
+
+	if got := (analyzeOptions{}).linkTimeout(); got != defaultLinkTimeout {
+		t.Errorf("want default %s, got %s", defaultLinkTimeout, got)
+	}
+
+	if _, err := srv.parseAnalyzeOptions(url.Values{"link_timeout": {"abc"}}); err == nil {
+		t.Errorf("expected error for non-numeric timeout")
+	}
+}
+
+func TestParseAnalyzeOptions_LinkRetries(t *testing.T) {
+	for v, want := range map[string]int{"": linkCheckRetries, "0": 0, "4": 4} {
+		opts, err := testServer(t, nil).parseAnalyzeOptions(url.Values{"link_retries": {v}})
+		if err != nil || opts.linkRetries() != want {
+			t.Errorf("link_retries=%q: want %d, got %d (err %v)", v, want, opts.linkRetries(), err)
+		}
+	}
+	if _, err := testServer(t, nil).parseAnalyzeOptions(url.Values{"link_retries": {"9"}}); err == nil {
+		t.Errorf("expected error for too many retries")
+	}
+}
+
+// --- Accepted link statuses ----------------------------------------------------
+func TestCheckLinks_AcceptStatusConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/moved":
+			http.Redirect(w, r, "/empty", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testSettings(t, func(c *Config) { c.AcceptStatus = "200, 204-206" })
+
+	check := func(path string) bool {
+		u, _ := url.Parse(srv.URL + path)
+		return checkLinks(t.Context(), []link{{URL: u}}, analyzeOptions{LinkTimeout: time.Second, cfg: cfg}).Inaccessible == 0
+	}
+	if !check("/empty") {
+		t.Errorf("want 204 accepted")
+	}
+	if check("/missing") {
+		t.Errorf("want 404 rejected")
+	}
+	if !check("/moved") {
+		t.Errorf("want redirect to 204 accepted")
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	r, err := parseStatusRanges("200-299,304")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if !r.contains(204) || !r.contains(304) || r.contains(301) || r.contains(404) {
+		t.Errorf("unexpected membership for %s", r)
+	}
+	if r.String() != "200-299,304" {
+		t.Errorf("round trip: got %q", r.String())
+	}
+	for _, bad := range []string{"", "abc", "300-200", "99", "200-700"} {
+		if _, err := parseStatusRanges(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+// --- Redirecting links -----------------------------------------------------------
+func TestCheckLinks_Redirecting(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var links []link
+	for _, p := range []string{"/old", "/direct"} {
+		u, _ := url.Parse(srv.URL + p)
+		links = append(links, link{URL: u})
+	}
+	sum := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: time.Second})
+	if sum.Checked != 2 || sum.Inaccessible != 0 || sum.Redirecting != 1 {
+		t.Fatalf("want 2 checked, 0 bad, 1 redirecting; got %+v", sum)
+	}
+	want := RedirectPair{From: srv.URL + "/old", To: srv.URL + "/new", InitialStatus: http.StatusMovedPermanently, Hops: 1, FinalStatus: http.StatusOK}
+	if len(sum.RedirectSamples) != 1 || sum.RedirectSamples[0] != want {
+		t.Fatalf("want sample %+v, got %+v", want, sum.RedirectSamples)
+	}
+}
+
+func TestCheckLinks_RedirectDetails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/gone", http.StatusMovedPermanently)
+		case "/gone":
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL + "/a")
+	sum := checkLinks(t.Context(), []link{{URL: u}}, analyzeOptions{LinkTimeout: time.Second})
+	want := RedirectPair{From: srv.URL + "/a", To: srv.URL + "/gone", InitialStatus: http.StatusFound, Hops: 2, FinalStatus: http.StatusNotFound}
+	if sum.Inaccessible != 1 || len(sum.RedirectSamples) != 1 || sum.RedirectSamples[0] != want {
+		t.Fatalf("want 1 broken link with sample %+v, got %+v", want, sum)
+	}
+}
+
+func TestCheckLinks_LinkResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/gone":
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var links []link
+	for _, raw := range []string{srv.URL + "/ok", srv.URL + "/moved", srv.URL + "/gone", "http://127.0.0.2:1/down"} {
+		u, _ := url.Parse(raw)
+		links = append(links, link{URL: u})
+	}
+	sum := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: time.Second})
+	if len(sum.Results) != 4 {
+		t.Fatalf("want 4 link results, got %+v", sum.Results)
+	}
+	byURL := make(map[string]LinkResult)
+	for _, r := range sum.Results {
+		byURL[r.URL] = r
+	}
+	for raw, want := range map[string]LinkResult{
+		srv.URL + "/ok":    {Status: http.StatusOK},
+		srv.URL + "/moved": {Status: http.StatusOK, Redirected: true},
+		srv.URL + "/gone":  {Status: http.StatusNotFound, Broken: true},
+	} {
+		want.URL = raw
+		if got := byURL[raw]; got != want {
+			t.Errorf("want %+v, got %+v", want, got)
+		}
+	}
+	if down := byURL["http://127.0.0.2:1/down"]; !down.Broken || down.Status != 0 || down.Error == "" {
+		t.Errorf("want a broken link with a transport error, got %+v", down)
+	}
+}
+
+// --- Resource exhaustion ---------------------------------------------------------
+func TestCheckLinks_ResourceExhaustionBackoff(t *testing.T) {
+	// one link per server, so no check can reuse another's connection and absorb a failed dial
+	var links []link
+	for range 3 {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		t.Cleanup(srv.Close)
+		u, _ := url.Parse(srv.URL + "/p")
+		links = append(links, link{URL: u})
+	}
+
+	// the first check of every link fails as if the process were out of file descriptors:
+	// both its HEAD and its GET fallback fail to dial
+	var mu sync.Mutex
+	failed := map[string]int{}
+	prev := linkDial
+	linkDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		failed[addr]++
+		n := failed[addr]
+		mu.Unlock()
+		if n <= 2 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: os.NewSyscallError("socket", syscall.EMFILE)}
+		}
+		return prev(ctx, network, addr)
+	}
+	t.Cleanup(func() { linkDial = prev })
+
+	sum := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: time.Second, Workers: 3})
+	if !sum.Degraded {
+		t.Fatalf("want degraded checks after EMFILE")
+	}
+	if sum.Checked != 3 || sum.Inaccessible != 0 {
+		t.Fatalf("want all 3 links checked and reachable after backoff, got %+v", sum)
+	}
+	// every check hit the limit, but the last slot is never retired
+	if sum.Retired != 2 {
+		t.Fatalf("want 2 of 3 worker slots retired, got %d", sum.Retired)
+	}
+}
+
+// --- Request budget ----------------------------------------------------------------
+func TestAnalyze_RequestBudget(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+
+	var b strings.Builder
+	b.WriteString("<!doctype html><body>")
+	for i := range 30 {
+		fmt.Fprintf(&b, `<a href="/p%d">p</a>`, i)
+	}
+	base, _ := url.Parse(srv.URL)
+	ctx := withRequestBudget(t.Context(), 10)
+	res, err := analyze(ctx, base, []byte(b.String()), analyzeOptions{})
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if hits.Load() != 10 || res.CheckedLinks != 10 || res.ChecksSkipped != 20 || res.InaccessibleLinks != 0 {
+		t.Fatalf("want 10 requests/checks and 20 skipped, got hits=%d checked=%d skipped=%d bad=%d",
+			hits.Load(), res.CheckedLinks, res.ChecksSkipped, res.InaccessibleLinks)
+	}
+	if !res.RequestBudgetHit {
+		t.Fatalf("want the budget noted in the result")
+	}
+}
+
+// --- robots.txt ------------------------------------------------------------------
+func TestParseRobots(t *testing.T) {
+	const txt = `
+# comment
+User-agent: *
+Disallow: /private
+Allow: /private/public
+
+User-agent: OtherBot
+User-agent: WebAnalyzer
+Disallow: /*.pdf$
+Disallow: /tmp/
+Allow: /tmp/keep
+
+User-agent: web
+Disallow: /
+`
+	rules := parseRobots(strings.NewReader(txt), robotsToken("webanalyzer/1.0 (+https://example.invalid)"))
+	for path, want := range map[string]bool{
+		"/private":         true, // our group has no rule for it
+		"/report.pdf":      false,
+		"/report.pdf?dl=1": true,
+		"/tmp/x":           false,
+		"/tmp/keep/x":      true,
+		"/":                true,
+	} {
+		u, _ := url.Parse("https://example.invalid" + path)
+		if got := rules.allows(u); got != want {
+			t.Errorf("%s: want allowed=%v, got %v", path, want, got)
+		}
+	}
+
+	rules = parseRobots(strings.NewReader(txt), "SomeoneElse")
+	for path, want := range map[string]bool{"/private/x": false, "/private/public/x": true, "/report.pdf": true} {
+		u, _ := url.Parse("https://example.invalid" + path)
+		if got := rules.allows(u); got != want {
+			t.Errorf("* group, %s: want allowed=%v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestRobotsToken(t *testing.T) {
+	for ua, want := range map[string]string{
+		defaultUserAgent:                  "webanalyzer",
+		"Mozilla/5.0 (X11; Linux x86_64)": "mozilla",
+		"  My_Crawler-Bot/2 ":             "my_crawler-bot",
+		"site-audit":                      "site-audit",
+		"":                                defaultRobotsAgent,
+		"(compatible; no token)":          defaultRobotsAgent,
+	} {
+		if got := robotsToken(ua); got != want {
+			t.Errorf("%q: want token %q, got %q", ua, want, got)
+		}
+	}
+}
+
+func TestAnalyze_RespectsRobots(t *testing.T) {
+	var robotsHits, privateHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsHits.Add(1)
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/":
+			_, _ = w.Write([]byte(`<!doctype html><title>Robots</title>
+			<a href="/public">a</a><a href="/private/1">b</a><a href="/private/2">c</a>`))
+		default:
+			if strings.HasPrefix(r.URL.Path, "/private") {
+				privateHits.Add(1)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{}).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.CheckedLinks != 1 || res.RobotsDisallowedLinks != 2 || privateHits.Load() != 0 || robotsHits.Load() != 1 {
+		t.Fatalf("want 1 checked, 2 disallowed, no private hits, robots.txt fetched once; got %d/%d/%d/%d",
+			res.CheckedLinks, res.RobotsDisallowedLinks, privateHits.Load(), robotsHits.Load())
+	}
+
+	res, err = New(Options{IgnoreRobots: true}).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.CheckedLinks != 3 || res.RobotsDisallowedLinks != 0 || privateHits.Load() != 2 {
+		t.Fatalf("want all 3 links checked with robots.txt ignored; got %d checked, %d private hits", res.CheckedLinks, privateHits.Load())
+	}
+}
+
+func TestRobotsCache_FetchIgnoresCallerBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	}))
+	t.Cleanup(srv.Close)
+
+	// the analysis that first needs the rules has used up its request budget
+	ctx := withRobotsCache(withRequestBudget(t.Context(), 1), testSettings(t, nil).robots)
+	budgetFrom(ctx).take()
+	var links []link
+	for _, p := range []string{"/private", "/public"} {
+		u, _ := url.Parse(srv.URL + p)
+		links = append(links, link{URL: u})
+	}
+	sum := checkLinks(ctx, links, analyzeOptions{})
+	if sum.Disallowed != 1 || sum.Skipped != 1 {
+		t.Fatalf("want robots.txt fetched outside the budget (1 disallowed, 1 skipped), got %d disallowed, %d skipped", sum.Disallowed, sum.Skipped)
+	}
+}
+
+func TestRobotsCache_SharedAndBounded(t *testing.T) {
+	var robotsHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsHits.Add(1)
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		_, _ = w.Write([]byte(`<!doctype html><title>Robots</title><a href="/public">a</a><a href="/private">b</a>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	a := New(Options{})
+	for range 3 {
+		if _, err := a.Analyze(t.Context(), srv.URL); err != nil {
+			t.Fatalf("analyze error: %v", err)
+		}
+	}
+	if robotsHits.Load() != 1 {
+		t.Fatalf("want robots.txt fetched once across analyses, got %d", robotsHits.Load())
+	}
+
+	a.opts.cfg = testSettings(t, func(c *Config) { c.RobotsTTL = time.Millisecond })
+	for range 2 {
+		if _, err := a.Analyze(t.Context(), srv.URL); err != nil {
+			t.Fatalf("analyze error: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if robotsHits.Load() != 3 {
+		t.Fatalf("want robots.txt fetched again once the rules expired, got %d fetches", robotsHits.Load())
+	}
+
+	c := newRobotsCache(time.Hour, 2, time.Second, defaultUserAgent)
+	for _, origin := range []string{"https://a.example", "https://b.example", "https://c.example"} {
+		c.entry(origin)
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := c.hosts["https://a.example"]; len(c.hosts) != 2 || ok {
+		t.Fatalf("want the oldest host evicted to stay within 2 entries, got %v", slices.Collect(maps.Keys(c.hosts)))
+	}
+}
+
+// --- Internal title map ------------------------------------------------------------
+func TestAnalyze_FetchTitles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte(`<!doctype html><title>Home</title>
+			<a href="/about">About</a><a href="/contact">Contact</a><a href="/missing">Gone</a>
+			<a href="https://example.invalid/x">External</a>`))
+		case "/about":
+			_, _ = w.Write([]byte(`<!doctype html><title> About us </title>`))
+		case "/contact":
+			_, _ = w.Write([]byte(`<!doctype html><title>Contact</title>` + strings.Repeat("x", 2*titleReadLimit)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{FetchTitles: true, MaxLinks: 1}).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	want := map[string]string{srv.URL + "/about": "About us", srv.URL + "/contact": "Contact"}
+	if !maps.Equal(res.InternalTitles, want) {
+		t.Fatalf("want titles %v, got %v", want, res.InternalTitles)
+	}
+
+	res, err = New(Options{MaxLinks: 1}).Analyze(t.Context(), srv.URL)
+	if err != nil || res.InternalTitles != nil {
+		t.Fatalf("want no titles without FetchTitles, got %v (err %v)", res.InternalTitles, err)
+	}
+}
+
+// --- Link-check budget -------------------------------------------------------------
+func TestAnalyze_LinkChecksHonorBudget(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte(`<!doctype html><title>Slow links</title>
+			<a href="/fast">fast</a><a href="/slow1">1</a><a href="/slow2">2</a><a href="/slow3">3</a>`))
+		case "/fast", "/robots.txt":
+		default:
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	res, err := New(Options{Budget: 500 * time.Millisecond, LinkTimeout: 10 * time.Second, Workers: 2}).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Fatalf("want the analysis to stop at its budget, took %s", took)
+	}
+	if res.CheckedLinks != 1 || res.InaccessibleLinks != 0 || res.ChecksTimedOut != 3 {
+		t.Fatalf("want 1 checked, 0 broken, 3 timed out; got %d, %d, %d", res.CheckedLinks, res.InaccessibleLinks, res.ChecksTimedOut)
+	}
+	if !res.BudgetExceeded || res.Title != "Slow links" || res.InternalLinks != 4 {
+		t.Fatalf("want a partial result flagged as such, got budgetExceeded=%v, title %q, %d internal links", res.BudgetExceeded, res.Title, res.InternalLinks)
+	}
+}
+
+// --- Global worker pool ------------------------------------------------------------
+func TestCheckLinks_GlobalPool(t *testing.T) {
+	cfg := testSettings(t, func(c *Config) { c.GlobalLinkWorkers = 3 })
+
+	var inFlight, peak atomic.Int32
+	links := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	t.Cleanup(links.Close)
+
+	var wg sync.WaitGroup
+	for a := 0; a < 4; a++ {
+		var ls []link
+		for i := 0; i < 8; i++ {
+			u, _ := url.Parse(fmt.Sprintf("%s/%d/%d", links.URL, a, i))
+			ls = append(ls, link{URL: u})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sum := checkLinks(t.Context(), ls, analyzeOptions{Workers: 12, cfg: cfg}); sum.Checked != len(ls) {
+				t.Errorf("want %d links checked, got %d", len(ls), sum.Checked)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > 3 || got < 2 {
+		t.Fatalf("want at most 3 checks in flight across analyses, peaked at %d", got)
+	}
+}
+
+// --- Tracking-param de-duplication ----------------------------------------------
+func TestCheckLinks_StripQueryParams(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testSettings(t, func(c *Config) { c.StripParams = "utm_*, fbclid" })
+
+	var links []link
+	for _, raw := range []string{"/p?id=1&utm_source=news", "/p?id=1&utm_medium=mail&fbclid=x", "/p?id=2"} {
+		u, _ := url.Parse(srv.URL + raw)
+		links = append(links, link{URL: u})
+	}
+	checked := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: time.Second, cfg: cfg}).Checked
+	if checked != 2 || hits.Load() != 2 {
+		t.Fatalf("want 2 checks (utm variants merged), got checked=%d hits=%d", checked, hits.Load())
+	}
+	if got := links[0].URL.RawQuery; got != "id=1&utm_source=news" {
+		t.Fatalf("raw URL should be kept for reporting, got %q", got)
+	}
+}
+
+// --- Global rate limit ---------------------------------------------------------
+func TestOutboundRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	// a burst of 1 makes every request after the first wait its full interval
+	const perSecond = 40
+	cfg := testSettings(t, nil)
+	cfg.limiter = rate.NewLimiter(perSecond, 1)
+
+	var links []link
+	for i := 0; i < 20; i++ {
+		u, _ := url.Parse(fmt.Sprintf("%s/p%d", srv.URL, i))
+		links = append(links, link{URL: u, IsInternal: true})
+	}
+	start := time.Now()
+	if sum := checkLinks(t.Context(), links, analyzeOptions{LinkTimeout: time.Second, cfg: cfg}); sum.Inaccessible != 0 || sum.Checked != 20 {
+		t.Fatalf("want 20 accessible links, got bad=%d checked=%d", sum.Inaccessible, sum.Checked)
+	}
+	elapsed := time.Since(start)
+	// 20 requests at 40/s need at least 19 intervals of 25ms; one interval of slack
+	if floor := 19 * time.Second / perSecond; elapsed < floor-time.Second/perSecond {
+		t.Fatalf("requests exceeded the rate limit: 20 requests in %s (want >= %s)", elapsed, floor)
+	}
+}
+
+// --- Fetch + status via httptest (no internet) -------------------------------
+func TestFetch_StatusAndRedirect(t *testing.T) {
+	// final 200 server
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte("<!doctype html><title>OK</title>"))
+	}))
+	t.Cleanup(ok.Close)
+
+	// redirecting server -> to ok.URL
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ok.URL, http.StatusMovedPermanently) // 301
+	}))
+	t.Cleanup(redirect.Close)
+
+	// Use our fetch to follow redirect
+	resp, page, err := fetch(t.Context(), redirect.URL, analyzeOptions{})
+	if err != nil {
+		t.Fatalf("fetch error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected final status 200, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(page.Body), "<title>OK</title>") {
+		t.Fatalf("unexpected body: %q", string(page.Body))
+	}
+}
+
+func TestHandleAnalyzeJSON_RedirectLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next := map[string]string{"/": "/a", "/a": "/b", "/b": "/c"}
+		if to, ok := next[r.URL.Path]; ok {
+			http.Redirect(w, r, to, http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("<!doctype html><title>Final</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	type redirectFields struct {
+		HTTPStatus      int           `json:"httpStatus"`
+		RedirectChain   []RedirectHop `json:"redirectChain"`
+		RedirectsCapped bool          `json:"redirectsCapped"`
+		RedirectError   string        `json:"redirectError"`
+	}
+	analyzeWith := func(query string) redirectFields {
+		rec := httptest.NewRecorder()
+		testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=httpStatus,redirectChain,redirectsCapped,redirectError&u="+url.QueryEscape(srv.URL+"/")+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var got redirectFields
+		_ = json.Unmarshal(rec.Body.Bytes(), &got)
+		for i := range got.RedirectChain {
+			got.RedirectChain[i].DurationMs = 0 // timing is covered by TestFetch_RedirectLatency
+		}
+		return got
+	}
+
+	got := analyzeWith("&max_redirects=2")
+	want := []RedirectHop{{URL: srv.URL + "/", Status: http.StatusFound}, {URL: srv.URL + "/a", Status: http.StatusFound}, {URL: srv.URL + "/b", Status: http.StatusFound}}
+	if !got.RedirectsCapped || got.HTTPStatus != http.StatusFound || !strings.Contains(got.RedirectError, "too many redirects") {
+		t.Fatalf("want capped at a 302 with an error, got %+v", got)
+	}
+	if !slices.Equal(got.RedirectChain, want) {
+		t.Fatalf("want partial chain %v, got %v", want, got.RedirectChain)
+	}
+
+	got = analyzeWith("")
+	want = append(want, RedirectHop{URL: srv.URL + "/c", Status: http.StatusOK})
+	if got.RedirectsCapped || got.RedirectError != "" || got.HTTPStatus != http.StatusOK || !slices.Equal(got.RedirectChain, want) {
+		t.Fatalf("want the full chain %v with the default limit, got %+v", want, got)
+	}
+}
+
+func TestHandleAnalyzeJSON_CompareAMP(t *testing.T) {
+	var ampHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/amp":
+			ampHits.Add(1)
+			_, _ = w.Write([]byte(`<!doctype html><html amp><head><title>News</title><link rel="canonical" href="/"></head>
+			<body><h1>News</h1></body></html>`))
+		case "/":
+			_, _ = w.Write([]byte(`<!doctype html><html><head><title>News</title><link rel="amphtml" href="/amp"></head>
+			<body><h1>News</h1><h2>More</h2><a href="/x">x</a></body></html>`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?amp=1&fields=ampCounterpart,amp&u="+url.QueryEscape(srv.URL+"/"), nil))
+	var got struct {
+		AMPCounterpart string
+		AMP            *AMPComparison
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v (%s)", err, rec.Body)
+	}
+	if got.AMPCounterpart != srv.URL+"/amp" || got.AMP == nil || got.AMP.Error != "" {
+		t.Fatalf("want the AMP page analyzed, got %s", rec.Body)
+	}
+	if !got.AMP.TitleMatches || got.AMP.HeadingsMatch || got.AMP.LinksMatch || got.AMP.Headings[2] != 0 {
+		t.Fatalf("want same title but different headings and links, got %+v", got.AMP)
+	}
+	if ampHits.Load() != 1 {
+		t.Fatalf("want the AMP page fetched once, got %d", ampHits.Load())
+	}
+
+	rec = httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=amp&u="+url.QueryEscape(srv.URL+"/"), nil))
+	if !strings.Contains(rec.Body.String(), `"amp":null`) || ampHits.Load() != 1 {
+		t.Fatalf("want no AMP analysis without amp=1, got %s", rec.Body)
+	}
+}
+
+func TestRunAnalysis_PastedHTML(t *testing.T) {
+	var hits []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, r.URL.Path)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	pasted := `<!doctype html><title>Staging</title><h1>Hi</h1><a href="/about">About</a><a href="http://127.0.0.2:1/">Out</a>`
+	analyzePasted := func(form url.Values) *pageData {
+		req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		pd, status := testServer(t, nil).runAnalysis(t.Context(), req)
+		if status != http.StatusOK || pd.Result == nil {
+			t.Fatalf("want a result, got %d: %s", status, pd.Error)
+		}
+		return pd
+	}
+
+	pd := analyzePasted(url.Values{"u": {srv.URL + "/staging"}, "html": {pasted}})
+	res := pd.Result
+	if !res.Pasted || pd.HTTPStatus != 0 || pd.CanonicalURL != srv.URL+"/staging" || res.Title != "Staging" {
+		t.Fatalf("want the pasted page analyzed at its base URL, got status %d, URL %s, %+v", pd.HTTPStatus, pd.CanonicalURL, res)
+	}
+	if res.InternalLinks != 1 || res.ExternalLinks != 1 || res.CheckedLinks != 0 || len(hits) != 0 {
+		t.Fatalf("want links resolved against the base and nothing fetched, got %d internal, %d external, %d checked, hits %v",
+			res.InternalLinks, res.ExternalLinks, res.CheckedLinks, hits)
+	}
+	if err := pageTmpl.Execute(io.Discard, pd); err != nil {
+		t.Fatalf("template error: %v", err)
+	}
+
+	pd = analyzePasted(url.Values{"u": {srv.URL + "/staging"}, "html": {pasted}, "check_links": {"1"}})
+	mu.Lock()
+	defer mu.Unlock()
+	if pd.Result.CheckedLinks != 2 || slices.Contains(hits, "/staging") || !slices.Contains(hits, "/about") {
+		t.Fatalf("want only the links checked, got %d checked, hits %v", pd.Result.CheckedLinks, hits)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/analyze?mode=headers-only&u=https://example.invalid&html="+url.QueryEscape(pasted), nil)
+	if _, status := testServer(t, nil).runAnalysis(t.Context(), req); status != http.StatusBadRequest {
+		t.Fatalf("want 400 for pasted HTML in headers-only mode, got %d", status)
+	}
+}
+
+func TestRunAnalysis_HeadersOnly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("Strict-Transport-Security", "max-age=63072000")
+		w.Header().Set("X-Powered-By", "Next.js")
+		_, _ = w.Write([]byte(`<!doctype html><title>Body</title><h1>never parsed</h1>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	req := httptest.NewRequest(http.MethodGet, "/analyze?mode=headers-only&u="+url.QueryEscape(srv.URL), nil)
+	pd, status := testServer(t, nil).runAnalysis(t.Context(), req)
+	if status != http.StatusOK || pd.Result == nil {
+		t.Fatalf("want a result, got %d: %s", status, pd.Error)
+	}
+	res := pd.Result
+	// analyze always sets a title and HTML version; empty ones mean the body was not parsed
+	if !res.HeadersOnly || res.Title != "" || res.HTMLVersion != "" || res.Headings != nil {
+		t.Fatalf("want no body analysis, got title=%q version=%q headings=%v", res.Title, res.HTMLVersion, res.Headings)
+	}
+	if pd.HTTPStatus != http.StatusOK || res.Server != "nginx" || res.SecurityHeaders["Strict-Transport-Security"] != "max-age=63072000" {
+		t.Fatalf("header fields not populated: status=%d %+v", pd.HTTPStatus, res)
+	}
+	if !slices.Contains(res.MissingSecurityHeaders, "Content-Security-Policy") || !slices.Equal(res.DetectedTech, []string{"Next.js"}) || !res.Indexable {
+		t.Fatalf("want missing CSP, Next.js from headers and indexable, got %+v", res)
+	}
+	if err := pageTmpl.Execute(io.Discard, pd); err != nil {
+		t.Fatalf("template error: %v", err)
+	}
+}
+
+func TestFetch_OverlongRedirect(t *testing.T) {
+	cfg := testSettings(t, func(c *Config) { c.MaxURLLength = 100 })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/next?junk="+strings.Repeat("x", 200), http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("<!doctype html><title>T</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	_, _, err := fetch(t.Context(), srv.URL+"/", analyzeOptions{cfg: cfg})
+	if err == nil || !strings.Contains(err.Error(), "over the 100-byte URL limit") {
+		t.Fatalf("want URL limit error, got %v", err)
+	}
+}
+
+func TestAnalyze_NoSniff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("xcto"); v != "" {
+			w.Header().Set("X-Content-Type-Options", v)
+		}
+		_, _ = w.Write([]byte("<!doctype html><title>T</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	for query, want := range map[string]bool{"": false, "?xcto=nosniff": true, "?xcto=NoSniff": true, "?xcto=sniff": false} {
+		res, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL+"/"+query)
+		if err != nil {
+			t.Fatalf("%q: analyze error: %v", query, err)
+		}
+		if res.NoSniff != want {
+			t.Errorf("%q: want NoSniff %v, got %v", query, want, res.NoSniff)
+		}
+	}
+}
+
+func TestFetch_RedirectLatency(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			time.Sleep(200 * time.Millisecond)
+			http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+			return
+		}
+		_, _ = w.Write([]byte("<!doctype html><title>Final</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL+"/")
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.RedirectLatencyMs < 200 || res.FinalFetchMs >= 200 {
+		t.Fatalf("want >= 200ms redirecting and a fast final page, got %dms and %dms", res.RedirectLatencyMs, res.FinalFetchMs)
+	}
+	if len(res.RedirectChain) != 2 || res.RedirectChain[0].DurationMs < 200 || res.RedirectChain[1].DurationMs >= 200 {
+		t.Fatalf("want a slow first hop and a fast second one, got %+v", res.RedirectChain)
+	}
+}
+
+func TestFetch_InsecureTLSHosts(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<!doctype html><title>Self-signed</title>"))
+	}))
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	opts := analyzeOptions{cfg: testSettings(t, func(c *Config) { c.InsecureTLSHosts = "LOCALHOST" })}
+
+	// the test certificate is not trusted and not issued for "localhost"
+	resp, _, err := fetch(t.Context(), "https://localhost:"+port, opts)
+	if err != nil {
+		t.Fatalf("want verification skipped for the allowlisted host, got %v", err)
+	}
+	_ = resp.Body.Close()
+
+	_, _, err = fetch(t.Context(), srv.URL, opts)
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("want a certificate error for a host not on the list, got %v", err)
+	}
+}
+
+func TestFetch_HTTP1Only(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<!doctype html><title>" + r.Proto + "</title>"))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	for _, only := range []bool{false, true} {
+		cfg := testSettings(t, func(c *Config) { c.HTTP1 = only })
+		cfg.rootCAs = x509.NewCertPool()
+		cfg.rootCAs.AddCert(srv.Certificate())
+		resp, _, err := fetch(t.Context(), srv.URL, analyzeOptions{cfg: cfg})
+		if err != nil {
+			t.Fatalf("HTTP1=%v: fetch error: %v", only, err)
+		}
+		_ = resp.Body.Close()
+		if want := map[bool]int{false: 2, true: 1}[only]; resp.ProtoMajor != want {
+			t.Errorf("HTTP1=%v: want HTTP/%d, got %s", only, want, resp.Proto)
+		}
+	}
+}
+
+func TestFetch_CABundle(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Private CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<!doctype html><title>Private CA</title>"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leafDER}, PrivateKey: leafKey}}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	if _, _, err := fetch(t.Context(), srv.URL, analyzeOptions{}); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("want a certificate error without the CA bundle, got %v", err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testSettings(t, func(c *Config) { c.CABundle = bundle })
+	resp, _, err := fetch(t.Context(), srv.URL, analyzeOptions{cfg: cfg})
+	if err != nil {
+		t.Fatalf("want the private CA trusted, got %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if _, err := loadCABundle(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("want an error for a missing bundle")
+	}
+}
+
+func TestFetch_DNSTimeout(t *testing.T) {
+	prevLookup := lookupIPAddr
+	t.Cleanup(func() { lookupIPAddr = prevLookup })
+	opts := analyzeOptions{cfg: testSettings(t, func(c *Config) { c.DNSTimeout = 50 * time.Millisecond })}
+
+	// a resolver that never answers
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	_, _, err := fetch(t.Context(), "http://slow-dns.example/", opts)
+	if err == nil || !strings.Contains(err.Error(), "DNS lookup for slow-dns.example timed out after 50ms") {
+		t.Fatalf("want a DNS timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("DNS timeout took %s", elapsed)
+	}
+
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	_, _, err = fetch(t.Context(), "http://nowhere.invalid/", opts)
+	if err == nil || !strings.Contains(err.Error(), "DNS lookup for nowhere.invalid failed") {
+		t.Fatalf("want a DNS failure error, got %v", err)
+	}
+}
+
+func TestResolveThenDial_Cache(t *testing.T) {
+	prevLookup := lookupIPAddr
+	t.Cleanup(func() { lookupIPAddr = prevLookup })
+	var lookups atomic.Int32
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups.Add(1)
+		return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+	}
+	var dialed []string
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		c1, c2 := net.Pipe()
+		_ = c2.Close()
+		return c1, nil
+	}
+
+	cache := newDNSCache(50*time.Millisecond, 2)
+	dialer := resolveThenDial(dial, time.Second, cache)
+	for _, addr := range []string{"a.example:80", "A.example:443", "b.example:80", "c.example:80", "a.example:80"} {
+		conn, err := dialer(t.Context(), "tcp", addr)
+		if err != nil {
+			t.Fatalf("%s: dial error: %v", addr, err)
+		}
+		_ = conn.Close()
+	}
+	// a.example is looked up once for both ports, then evicted as the oldest for c.example
+	if n := lookups.Load(); n != 4 {
+		t.Fatalf("want 4 lookups, got %d", n)
+	}
+	if dialed[1] != "192.0.2.1:443" {
+		t.Fatalf("want the cached address dialed, got %v", dialed)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := cache.get("c.example"); ok {
+		t.Fatal("want the entry expired after the TTL")
+	}
+
+	lookups.Store(0)
+	dialer = resolveThenDial(dial, time.Second, newDNSCache(0, 2))
+	for range 2 {
+		conn, err := dialer(t.Context(), "tcp", "a.example:80")
+		if err != nil {
+			t.Fatalf("dial error: %v", err)
+		}
+		_ = conn.Close()
+	}
+	if n := lookups.Load(); n != 2 {
+		t.Fatalf("want every dial looked up without a cache, got %d lookups", n)
+	}
+}
+
+func TestFetch_SlowBodyWithinFetchTimeout(t *testing.T) {
+	opts := analyzeOptions{cfg: testSettings(t, func(c *Config) {
+		c.HeaderTimeout, c.FetchTimeout = 200*time.Millisecond, 5*time.Second
+	})}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/late" {
+			time.Sleep(time.Second)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<!doctype html><title>Slow</title>`))
+		// headers arrive at once; the rest of the body trickles in well past the header timeout
+		for range 6 {
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+			_, _ = w.Write([]byte("<p>chunk</p>"))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	_, page, err := fetch(t.Context(), srv.URL+"/", opts)
+	if err != nil {
+		t.Fatalf("slow but streaming body was cut off: %v", err)
+	}
+	if n := strings.Count(string(page.Body), "<p>chunk</p>"); n != 6 {
+		t.Fatalf("want all 6 chunks, got %d", n)
+	}
+
+	if _, _, err = fetch(t.Context(), srv.URL+"/late", opts); err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Fatalf("want a response header timeout, got %v", err)
+	}
+}
+
+func TestFetch_ContentTypeGuard(t *testing.T) {
+	var contentType string
+	opts := analyzeOptions{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write([]byte("<!doctype html><title>T</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	fetchAs := func(ct string) error {
+		contentType = ct
+		resp, _, err := fetch(t.Context(), srv.URL, opts)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := fetchAs("application/xhtml+xml; charset=utf-8"); err != nil {
+		t.Fatalf("xhtml should be accepted by default: %v", err)
+	}
+	if err := fetchAs("application/json"); err == nil {
+		t.Fatalf("expected json to be rejected")
+	}
+
+	opts.cfg = testSettings(t, func(c *Config) { c.HTMLTypes += ",text/x-custom-html" })
+	if err := fetchAs("text/x-custom-html"); err != nil {
+		t.Fatalf("configured type should be accepted: %v", err)
+	}
+}
+
+func TestFetch_CookieRefetch(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if c, err := r.Cookie("session"); err == nil && c.Value == "ok" {
+			_, _ = w.Write([]byte("<!doctype html><title>Real content</title>"))
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+		_, _ = w.Write([]byte("<!doctype html><title>Cookie check</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := analyzeOptions{}
+	fetchTitle := func() string {
+		resp, page, err := fetch(t.Context(), srv.URL, opts)
+		if err != nil {
+			t.Fatalf("fetch error: %v", err)
+		}
+		_ = resp.Body.Close()
+		return string(page.Body)
+	}
+
+	if body := fetchTitle(); !strings.Contains(body, "Cookie check") {
+		t.Fatalf("without refetch want the cookie-gate page, got %q", body)
+	}
+
+	opts.cfg = testSettings(t, func(c *Config) { c.CookieRefetch = true })
+	requests.Store(0)
+	if body := fetchTitle(); !strings.Contains(body, "Real content") {
+		t.Fatalf("with refetch want the real page, got %q", body)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("want 2 requests with refetch, got %d", n)
+	}
+}
+
+func TestUserAgent_AllRequests(t *testing.T) {
+	var mu sync.Mutex
+	agents := map[string]string{} // path => User-Agent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.URL.Path] = r.UserAgent()
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte(`<!doctype html><title>UA</title><a href="/old">link</a>`))
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	for override, want := range map[string]string{"": defaultUserAgent, "CustomBot/2.0": "CustomBot/2.0"} {
+		clear(agents)
+		// each Analyzer has its own robots.txt cache, so robots.txt is fetched again
+		if _, err := New(Options{UserAgent: override}).Analyze(t.Context(), srv.URL); err != nil {
+			t.Fatalf("analyze error: %v", err)
+		}
+		for _, path := range []string{"/", "/robots.txt", "/old", "/new"} {
+			if got := agents[path]; got != want {
+				t.Errorf("%s: want User-Agent %q, got %q", path, want, got)
+			}
+		}
+	}
+}
+
+func TestFetch_NoCacheHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		_, _ = w.Write([]byte("<!doctype html><title>T</title>"))
+	}))
+	t.Cleanup(srv.Close)
+
+	resp, _, err := fetch(t.Context(), srv.URL, analyzeOptions{})
+	if err != nil {
+		t.Fatalf("fetch error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if got.Get("Cache-Control") != "" || got.Get("Pragma") != "" {
+		t.Fatalf("cache headers sent without the option: %v", got)
+	}
+
+	opts, _ := testServer(t, nil).parseAnalyzeOptions(url.Values{"nocache": {"on"}})
+	resp, _, err = fetch(t.Context(), srv.URL, opts)
+	if err != nil {
+		t.Fatalf("fetch error: %v", err)
+	}
+	_ = resp.Body.Close()
+	if got.Get("Cache-Control") != "no-cache" || got.Get("Pragma") != "no-cache" {
+		t.Fatalf("want no-cache headers, got Cache-Control=%q Pragma=%q", got.Get("Cache-Control"), got.Get("Pragma"))
+	}
+}
+
+func TestFetch_GzipSizes(t *testing.T) {
+	html := "<!doctype html><title>Z</title>" + strings.Repeat("<p>hello world</p>", 500)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte(html))
+	_ = zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = w.Write([]byte(html))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gz.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+
+	resp, page, err := fetch(t.Context(), srv.URL, analyzeOptions{})
+	if err != nil {
+		t.Fatalf("fetch error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if page.ContentEncoding != "gzip" {
+		t.Fatalf("want gzip encoding, got %q", page.ContentEncoding)
+	}
+	if page.TransferSize != gz.Len() {
+		t.Errorf("transfer size: want %d, got %d", gz.Len(), page.TransferSize)
+	}
+	if len(page.Body) != len(html) {
+		t.Errorf("decoded size: want %d, got %d", len(html), len(page.Body))
+	}
+	want := float64(len(html)) / float64(gz.Len())
+	if got := page.compressionRatio(); got != want || got <= 1 {
+		t.Errorf("ratio: want %.2f, got %.2f", want, got)
+	}
+}
+
+func TestAnalyze_DecodesCharset(t *testing.T) {
+	pages := map[string]struct{ contentType, body string }{
+		"/header": {"text/html; charset=Shift_JIS", "<!doctype html><title>\x93\xfa\x96\x7b</title>"},
+		"/meta":   {"text/html", `<!doctype html><meta charset="iso-8859-1"><title>Caf` + "\xe9</title>"},
+		"/utf8":   {"text/html", "<!doctype html><title>Café</title>"},
+		"/bare":   {"text/html", "<!doctype html><title>Caf\xe9</title>"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := pages[r.URL.Path]
+		w.Header().Set("Content-Type", p.contentType)
+		_, _ = w.Write([]byte(p.body))
+	}))
+	t.Cleanup(srv.Close)
+
+	cases := []struct{ path, title, charset string }{
+		{"/header", "日本", "shift_jis"},
+		{"/meta", "Café", "windows-1252"},
+		{"/utf8", "Café", "utf-8"},
+		{"/bare", "Café", "windows-1252"},
+	}
+	for _, c := range cases {
+		res, err := New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL+c.path)
+		if err != nil {
+			t.Fatalf("%s: analyze error: %v", c.path, err)
+		}
+		if res.Title != c.title || res.Charset != c.charset {
+			t.Errorf("%s: want title %q (%s), got %q (%s)", c.path, c.title, c.charset, res.Title, res.Charset)
+		}
+	}
+}
+
+func TestFetch_BodyTooLarge(t *testing.T) {
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	_, _ = zw.Write([]byte("<!doctype html><title>Bomb</title>"))
+	_, _ = zw.Write(make([]byte, 8<<20))
+	_ = zw.Close()
+	plain := "<!doctype html><title>Big</title>" + strings.Repeat("x", 2<<20)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bomb" {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(bomb.Bytes())
+			return
+		}
+		_, _ = w.Write([]byte(plain))
+	}))
+	t.Cleanup(srv.Close)
+
+	for _, path := range []string{"/bomb", "/plain"} {
+		_, _, err := fetch(t.Context(), srv.URL+path, analyzeOptions{BodyLimit: 1 << 20})
+		if !errors.Is(err, errBodyTooLarge) {
+			t.Fatalf("%s: want a response too large error, got %v", path, err)
+		}
+	}
+	if bomb.Len() > 1<<20 {
+		t.Fatalf("the bomb should be small on the wire, is %d bytes", bomb.Len())
+	}
+
+	resp, page, err := fetch(t.Context(), srv.URL+"/plain", analyzeOptions{BodyLimit: 1 << 20, PrefixOnly: true})
+	if err != nil {
+		t.Fatalf("want the prefix without an error, got %v", err)
+	}
+	_ = resp.Body.Close()
+	if len(page.Body) != 1<<20 {
+		t.Fatalf("want a 1MiB prefix, got %d bytes", len(page.Body))
+	}
+}
+
+func TestFetch_DecodedCapOnSideRequests(t *testing.T) {
+	bomb := func(prefix string) []byte {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		_, _ = zw.Write([]byte(prefix))
+		_, _ = zw.Write(bytes.Repeat([]byte(" "), 16<<20))
+		_ = zw.Close()
+		return b.Bytes()
+	}
+	errorPage := bomb("<!doctype html><title>Oops</title>")
+	robots := bomb("User-agent: *\nDisallow: /private\n")
+	titled := bomb("<!doctype html><title>Inflated</title>")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = w.Write(robots)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write(titled)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write(errorPage)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	resp, page, err := fetch(t.Context(), srv.URL+"/broken", analyzeOptions{BodyLimit: 1 << 20})
+	if err == nil || errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("want the non-OK status error, got %v", err)
+	}
+	_ = resp.Body.Close()
+	if page == nil || len(page.Body) != 1<<20 {
+		t.Fatalf("want the error page cut off at 1MiB, got %v bytes", page)
+	}
+
+	client := srv.Client()
+	pageURL, _ := url.Parse(srv.URL + "/page")
+	if got := fetchTitle(t.Context(), client, pageURL, splitList(defaultHTMLTypes)); got != "Inflated" {
+		t.Fatalf("want the title from the prefix, got %q", got)
+	}
+	privateURL, _ := url.Parse(srv.URL + "/private")
+	if testSettings(t, nil).robots.allows(client, privateURL) {
+		t.Fatal("want the rules read from the prefix of a compressed robots.txt")
+	}
+}
+
+// --- Concurrent analyses -------------------------------------------------------
+func TestLimitAnalyses(t *testing.T) {
+	srv := testServer(t, func(c *Config) { c.MaxAnalyses = 1 })
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := srv.limitAnalyses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("u") == "slow.example" {
+			close(entered)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/analyze?u=slow.example", nil))
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?u=example.com", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" || !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Fatalf("want a JSON 429 with Retry-After while the slot is taken, got %d %q: %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analyze?u=example.com", nil))
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "too many analyses are running") {
+		t.Fatalf("want the busy page with 429, got %d", rec.Code)
+	}
+
+	close(release)
+	<-done
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?u=example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want the request served once the slot is free, got %d", rec.Code)
+	}
+}
+
+// --- Handler deadline ----------------------------------------------------------
+func TestDeadlineMiddleware(t *testing.T) {
+	hang := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(50 * time.Millisecond) // ignore cancellation for a while, like a buggy handler
+		_, _ = w.Write([]byte("too late"))
+	})
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	deadlineMiddleware(hang, 50*time.Millisecond).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("want 504, got %d", rec.Code)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("middleware did not return promptly")
+	}
+
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "1")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("ok"))
+	})
+	rec = httptest.NewRecorder()
+	deadlineMiddleware(fast, time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "ok" || rec.Header().Get("X-Test") != "1" {
+		t.Fatalf("unexpected passthrough response: %d %q", rec.Code, rec.Body)
+	}
+}
+
+// --- Server configuration ------------------------------------------------------
+func TestNewHandler(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PerRequestTimeout, cfg.TotalBudget, cfg.LinkWorkers = 2*time.Second, 20*time.Second, 3
+	cfg.HandlerTimeout, cfg.HeaderTimeout, cfg.GlobalLinkWorkers = 0, 0, 0
+	s, err := newSettings(cfg)
+	if err != nil {
+		t.Fatalf("newSettings: %v", err)
+	}
+	if s.GlobalLinkWorkers != 12 || s.HeaderTimeout != 2*time.Second || s.HandlerTimeout != 20*time.Second+handlerDeadlineSlack {
+		t.Fatalf("want the derived defaults to follow the config, got %d workers, %s header timeout, %s handler timeout", s.GlobalLinkWorkers, s.HeaderTimeout, s.HandlerTimeout)
+	}
+	h, err := NewHandler(cfg)
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	if defaultSettings.TotalBudget != defaultBudget || (analyzeOptions{}).linkTimeout() != defaultLinkTimeout {
+		t.Fatalf("NewHandler changed the defaults of other handlers and Analyzers")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<form") {
+		t.Fatalf("want the index page, got %d", rec.Code)
+	}
+
+	cfg.PerRequestTimeout = time.Minute
+	if _, err := NewHandler(cfg); err == nil || !strings.Contains(err.Error(), "-per-request-timeout") {
+		t.Fatalf("want an error naming -per-request-timeout, got %v", err)
+	}
+}
+
+// --- Library API -------------------------------------------------------------
+func TestAnalyzer_Analyze(t *testing.T) {
+	var linkHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			_, _ = w.Write([]byte(`<!doctype html><title>Library</title><h1>Hi</h1>
+			<a href="/a">a</a><a href="/b">b</a><a href="/c">c</a>
+			<form><input type="password"></form>`))
+		case "/c":
+			linkHits.Add(1)
+			http.NotFound(w, r)
+		default:
+			linkHits.Add(1)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := DefaultOptions()
+	opts.MaxLinks, opts.Workers = 2, 1
+	res, err := New(opts).Analyze(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.URL != srv.URL {
+		t.Errorf("want final URL %s, got %s", srv.URL, res.URL)
+	}
+	if res.HTTPStatus != http.StatusOK || res.Title != "Library" || res.Headings[1] != 1 || !res.HasLogin || res.InternalLinks != 3 {
+		t.Errorf("unexpected result: status %d, title %q, h1 %d, login %v, internal %d",
+			res.HTTPStatus, res.Title, res.Headings[1], res.HasLogin, res.InternalLinks)
+	}
+	if res.CheckedLinks != 2 || res.CheckedLinksCap != 2 {
+		t.Errorf("want 2 links checked under a cap of 2, got %d (cap %d)", res.CheckedLinks, res.CheckedLinksCap)
+	}
+
+	linkHits.Store(0)
+	res, err = New(Options{SkipLinkChecks: true}).Analyze(t.Context(), srv.URL)
+	if err != nil || res.CheckedLinks != 0 || linkHits.Load() != 0 {
+		t.Fatalf("want no link checks, got %d checked, %d hits, err %v", res.CheckedLinks, linkHits.Load(), err)
+	}
+
+	if _, err := New(Options{}).Analyze(t.Context(), srv.URL+"/c"); err == nil {
+		t.Fatal("want an error for a 404 page")
+	}
+}
+
+func TestAnalyzer_FetchOptions(t *testing.T) {
+	var pageHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hop1":
+			http.Redirect(w, r, "/hop2", http.StatusFound)
+		case "/hop2":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/":
+			pageHits.Add(1)
+			if _, err := r.Cookie("seen"); err != nil {
+				http.SetCookie(w, &http.Cookie{Name: "seen", Value: "1"})
+			}
+			_, _ = w.Write([]byte(`<!doctype html><title>Options</title>`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	res, err := New(Options{HeadersOnly: true, BodyHash: true}).Analyze(t.Context(), srv.URL)
+	if err != nil || !res.HeadersOnly || res.Title != "" || res.BodyHash != "" {
+		t.Fatalf("want a headers-only result without title or hash, got %+v, err %v", res.Analysis, err)
+	}
+	res, err = New(Options{BodyHash: true, SkipLinkChecks: true}).Analyze(t.Context(), srv.URL)
+	if want := sha256.Sum256([]byte(`<!doctype html><title>Options</title>`)); err != nil || res.BodyHash != hex.EncodeToString(want[:]) {
+		t.Fatalf("want the body hash, got %q, err %v", res.BodyHash, err)
+	}
+	res, err = New(Options{MaxRedirects: 1}).Analyze(t.Context(), srv.URL+"/hop1")
+	if err != nil || !res.RedirectsCapped {
+		t.Fatalf("want the redirects capped after 1 hop, got capped=%v, err %v", res.RedirectsCapped, err)
+	}
+	pageHits.Store(0)
+	if _, err = New(Options{CookieRefetch: true}).Analyze(t.Context(), srv.URL); err != nil || pageHits.Load() != 2 {
+		t.Fatalf("want the page fetched twice, got %d fetches, err %v", pageHits.Load(), err)
+	}
+}
+
+// --- JSON API ----------------------------------------------------------------
+func TestAnalyzeJSON_FieldSelection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><title>Fields</title><h1>x</h1>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=title,htmlVersion&u="+url.QueryEscape(srv.URL), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 2 || got["title"] != "Fields" || got["htmlVersion"] != "HTML5" {
+		t.Fatalf("want only title and htmlVersion, got %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=title,nope&u="+url.QueryEscape(srv.URL), nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `unknown field \"nope\"`) {
+		t.Fatalf("want 400 for unknown field, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAnalyzeJSON_HeadingKeysAndErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><title>H</title><h1>a</h1><h2>b</h2><h2>c</h2>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=headings&u="+url.QueryEscape(srv.URL), nil))
+	if want := `{"headings":{"h1":1,"h2":2,"h3":0,"h4":0,"h5":0,"h6":0}}`; rec.Code != http.StatusOK || rec.Body.String() != want {
+		t.Fatalf("want %s, got %d: %s", want, rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json", nil))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" || !strings.Contains(rec.Body.String(), `"error":"please provide a URL"`) {
+		t.Fatalf("want a 400 JSON error, got %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+}
+
+func TestAnalyze_AcceptNegotiation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><title>Negotiated</title>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	for accept, wantJSON := range map[string]bool{
+		"application/json":                  true,
+		"text/html;q=0.5, application/json": true,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": false,
+		"": false,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/analyze?u="+url.QueryEscape(srv.URL), nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		testServer(t, nil).handleAnalyze(rec, req)
+		if gotJSON := rec.Header().Get("Content-Type") == "application/json"; gotJSON != wantJSON {
+			t.Errorf("Accept %q: want JSON %v, got Content-Type %q", accept, wantJSON, rec.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(rec.Body.String(), "Negotiated") {
+			t.Errorf("Accept %q: body lacks the title: %s", accept, rec.Body)
+		}
+	}
+}
+
+func TestHTMLVersionEndpoint_ReadsPrefixOnly(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`))
+		_, _ = w.Write(bytes.Repeat([]byte("<p>filler</p>"), htmlVersionPrefix))
+		w.(http.Flusher).Flush()
+		// the rest of the body never comes; a full read would hang until the timeout
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleHTMLVersion(rec, httptest.NewRequest(http.MethodGet, "/api/htmlversion?u="+url.QueryEscape(srv.URL), nil))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("took %s; the body should not be read to the end", elapsed)
+	}
+	var got htmlVersionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("want 200 JSON, got %d: %s", rec.Code, rec.Body)
+	}
+	if got.HTMLVersion != "XHTML 1.0 Strict" || got.HTTPStatus != http.StatusOK {
+		t.Fatalf("want XHTML 1.0 Strict with status 200, got %+v", got)
+	}
+}
+
+func TestAnalyzeJSON_SummaryFormat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`<!doctype html><title>Shop</title><body>
+		<a href="/gone">gone</a><a href="/">home</a>
+		<form><input type="password" name="pw"></form></body>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?format=summary&u="+url.QueryEscape(srv.URL), nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("want 200 text/plain, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	got := rec.Body.String()
+	for _, want := range []string{"HTTP 200", `"Shop"`, "HTML5", "2 internal and 0 external", "1 of 2 checked links are broken", "login form."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("summary lacks %q: %s", want, got)
+		}
+	}
+}
+
+func TestAnalyzeJSON_BodyHash(t *testing.T) {
+	const page = `<!doctype html><title>Hash me</title>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(page))
+	}))
+	t.Cleanup(srv.Close)
+
+	hashOf := func(query string) string {
+		rec := httptest.NewRecorder()
+		testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=bodyHash&u="+url.QueryEscape(srv.URL)+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var got struct{ BodyHash string }
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		return got.BodyHash
+	}
+	// echo -n '<!doctype html><title>Hash me</title>' | sha256sum
+	if want, got := "1f2a16da9260d25b62564ac307d6d30c99db34456869dc1a053834d39e3ed3c7", hashOf("&hash=1"); got != want {
+		t.Fatalf("want hash %s, got %s", want, got)
+	}
+	if got := hashOf(""); got != "" {
+		t.Fatalf("want no hash without hash=1, got %s", got)
+	}
+}
+
+func TestRecheckJSON_UpdatesLinkChecks(t *testing.T) {
+	var linkStatus atomic.Int32
+	linkStatus.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/link" {
+			w.WriteHeader(int(linkStatus.Load()))
+			return
+		}
+		_, _ = w.Write([]byte(`<!doctype html><title>Stored</title><a href="/link">link</a>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	type result struct {
+		ID                string
+		Title             string
+		InaccessibleLinks int
+		CheckedLinks      int
+	}
+	decode := func(rec *httptest.ResponseRecorder) result {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var got result
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		return got
+	}
+
+	api := testServer(t, nil)
+	rec := httptest.NewRecorder()
+	api.handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?u="+url.QueryEscape(srv.URL+"/"), nil))
+	first := decode(rec)
+	if first.ID == "" || first.InaccessibleLinks != 0 || first.CheckedLinks != 1 {
+		t.Fatalf("want an id and 1 healthy checked link, got %+v", first)
+	}
+
+	linkStatus.Store(http.StatusNotFound)
+	rec = httptest.NewRecorder()
+	api.handleRecheckJSON(rec, httptest.NewRequest(http.MethodGet, "/recheck.json?id="+first.ID, nil))
+	again := decode(rec)
+	if again.ID != first.ID || again.Title != "Stored" || again.InaccessibleLinks != 1 || again.CheckedLinks != 1 {
+		t.Fatalf("want the same analysis with 1 broken link, got %+v", again)
+	}
+	if pd, _ := api.cfg.history.get(first.ID); pd.Result.InaccessibleLinks != 1 {
+		t.Fatalf("want the stored analysis updated, got %d broken links", pd.Result.InaccessibleLinks)
+	}
+
+	rec = httptest.NewRecorder()
+	api.handleRecheckJSON(rec, httptest.NewRequest(http.MethodGet, "/recheck.json?id=nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404 for an unknown id, got %d", rec.Code)
+	}
+}
+
+func TestRecheckLinks_KeepsImageCheckTotals(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	// a budget of 2 requests: the link check and one image check; the other image is skipped
+	base, _ := url.Parse(srv.URL)
+	opts := analyzeOptions{CheckImages: true, IgnoreRobots: true}
+	res, err := analyze(withRequestBudget(t.Context(), 2), base, []byte(`<!doctype html><a href="/page">p</a><img src="/a.png"><img src="/b.png">`), opts)
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.CheckedLinks != 1 || res.CheckedImages != 1 || res.ChecksSkipped != 1 {
+		t.Fatalf("want 1 link and 1 image checked, 1 image skipped; got %d, %d, %d", res.CheckedLinks, res.CheckedImages, res.ChecksSkipped)
+	}
+
+	again := recheckLinks(t.Context(), &pageData{Result: res, Options: opts})
+	if again.Result.CheckedLinks != 1 || again.Result.ChecksSkipped != 1 {
+		t.Fatalf("want the skipped image still counted after a recheck, got %d checked, %d skipped", again.Result.CheckedLinks, again.Result.ChecksSkipped)
+	}
+}
+
+func TestAnalysisHistory_EvictsOldest(t *testing.T) {
+	h := newAnalysisHistory(2)
+	a := h.add(&pageData{InputURL: "a"})
+	h.add(&pageData{InputURL: "b"})
+	h.add(&pageData{InputURL: "c"})
+	if _, ok := h.get(a); ok {
+		t.Fatal("want the oldest analysis evicted")
+	}
+	if len(h.entries) != 2 {
+		t.Fatalf("want 2 stored analyses, got %d", len(h.entries))
+	}
+}
+
+func TestAnalyzeJSON_TimestampAndVersion(t *testing.T) {
+	prev := version
+	version = "v1.2.3"
+	t.Cleanup(func() { version = prev })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><title>When</title>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	testServer(t, nil).handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?fields=analyzedAt,toolVersion&u="+url.QueryEscape(srv.URL), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		AnalyzedAt  time.Time `json:"analyzedAt"`
+		ToolVersion string    `json:"toolVersion"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got.ToolVersion != "v1.2.3" || got.AnalyzedAt.Before(start.Truncate(time.Second)) || got.AnalyzedAt.After(time.Now()) {
+		t.Fatalf("want the version and a timestamp from this run, got %+v", got)
+	}
+}
+
+// --- Webhook -------------------------------------------------------------------
+func TestWebhook_PayloadAndSignature(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><title>Hooked</title>`))
+	}))
+	t.Cleanup(target.Close)
+
+	type delivery struct {
+		body []byte
+		sig  string
+	}
+	got := make(chan delivery, 1)
+	var attempts atomic.Int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first delivery to exercise the retry
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{body: body, sig: r.Header.Get(WebhookSignatureHeader)}
+	}))
+	t.Cleanup(hook.Close)
+
+	api := testServer(t, func(c *Config) { c.WebhookURL, c.WebhookSecret = hook.URL, "s3cret" })
+	rec := httptest.NewRecorder()
+	api.handleAnalyzeJSON(rec, httptest.NewRequest(http.MethodGet, "/analyze.json?u="+url.QueryEscape(target.URL), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var d delivery
+	select {
+	case d = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook not delivered")
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(d.body, &payload); err != nil {
+		t.Fatalf("invalid JSON payload: %v", err)
+	}
+	if payload["title"] != "Hooked" || payload["canonicalURL"] != target.URL {
+		t.Fatalf("unexpected payload: %v", payload)
+	}
+	var resp struct{ ID string }
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.ID == "" || payload["id"] != resp.ID {
+		t.Fatalf("want the payload to carry the analysis ID %q for /recheck.json, got %v", resp.ID, payload["id"])
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(d.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); d.sig != want {
+		t.Fatalf("want signature %s, got %s", want, d.sig)
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("want 2 delivery attempts, got %d", n)
+	}
+}
+
+// --- Tracing -----------------------------------------------------------------
+func TestHandleAnalyze_EmitsSpans(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	prev := tracer
+	tracer = tp.Tracer(tracerName)
+	t.Cleanup(func() { tracer = prev })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><title>T</title><a href="/ok">ok</a>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(url.Values{"u": {srv.URL}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	testServer(t, nil).handleAnalyze(httptest.NewRecorder(), req)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exp.GetSpans() {
+		spans[s.Name] = s
+	}
+	for _, name := range []string{"handleAnalyze", "fetch", "checkLinks"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("missing span %q (got %d spans)", name, len(spans))
+		}
+	}
+	if got := spanAttr(spans["handleAnalyze"], "http.response.status_code"); got.AsInt64() != 200 {
+		t.Errorf("handleAnalyze status attr: want 200, got %v", got.Emit())
+	}
+	if got := spanAttr(spans["checkLinks"], "links.checked"); got.AsInt64() != 1 {
+		t.Errorf("checkLinks links.checked attr: want 1, got %v", got.Emit())
+	}
+}
+
+// --- Logging -----------------------------------------------------------------
+func TestHandlerMiddleware_LogsRequests(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	h := handlerMiddleware(deadlineMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logAnalyzedURL(r.Context(), "example.com")
+		w.WriteHeader(http.StatusTeapot)
+	}), time.Second))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/analyze", nil))
+
+	var line struct {
+		Level, Msg, Method, Path, URL string
+		Status                        int
+		Duration                      int64
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want one JSON log line, got %q: %v", buf.String(), err)
+	}
+	if line.Level != "INFO" || line.Msg != "request" || line.Method != "POST" || line.Path != "/analyze" ||
+		line.Status != http.StatusTeapot || line.URL != "example.com" || line.Duration <= 0 {
+		t.Fatalf("unexpected log line %s", buf.String())
+	}
+}
+
+// --- Metrics -----------------------------------------------------------------
+func TestMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`<!doctype html><title>T</title><a href="/ok">ok</a><a href="/gone">gone</a>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	m := http.NewServeMux()
+	m.HandleFunc("/analyze", testServer(t, nil).handleAnalyze)
+	m.Handle("/metrics", metricsHandler())
+	h := handlerMiddleware(m)
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	before := scrape()
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(url.Values{"u": {srv.URL}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader(url.Values{"u": {"http://127.0.0.2:1/"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	after := scrape()
+
+	for _, c := range []struct {
+		series string
+		want   float64
+	}{
+		{`webanalyzer_analyses_total{outcome="success"}`, 1},
+		{`webanalyzer_analyses_total{outcome="error"}`, 1},
+		{`webanalyzer_analysis_duration_seconds_count`, 2},
+		{`webanalyzer_fetch_duration_seconds_count`, 2},
+		{`webanalyzer_links_checked_total`, 2},
+		{`webanalyzer_links_broken_total`, 1},
+		{`webanalyzer_http_request_duration_seconds_count{route="/analyze"}`, 2},
+	} {
+		if got := metricValue(after, c.series) - metricValue(before, c.series); got != c.want {
+			t.Errorf("%s: want +%v, got +%v", c.series, c.want, got)
+		}
+	}
+}
+
+// --- helpers ----------------------------------------------------------------
+
+// spanAttr returns the value of the named attribute on a recorded span.
+func spanAttr(s tracetest.SpanStub, key string) attribute.Value {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// analyzeFromHTML lets us bypass real fetch in unit tests.
+func analyzeFromHTML(base *url.URL, html string) (*Analysis, error) {
+	_, _ = goquery.NewDocumentFromReader(strings.NewReader(html))
+	// emulate what analyze() does internally using the parsed document:
+	// We'll reuse the real 'analyze' by passing body bytes to it.
+	return analyze(tContext(), base, []byte(html), analyzeOptions{})
+}
+
+// testSettings returns the settings of DefaultConfig changed by edit, which may be nil.
+func testSettings(t *testing.T, edit func(*Config)) *settings {
+	t.Helper()
+	cfg := DefaultConfig()
+	if edit != nil {
+		edit(&cfg)
+	}
+	s, err := newSettings(cfg)
+	if err != nil {
+		t.Fatalf("newSettings: %v", err)
+	}
+	return s
+}
+
+// testServer returns a server with testSettings(t, edit).
+func testServer(t *testing.T, edit func(*Config)) *server {
+	t.Helper()
+	return newServer(testSettings(t, edit))
+}
+
+// tContext returns a background-like context for tests.
+func tContext() context.Context { return context.Background() }
+
+// metricValue returns the value of series in a Prometheus text exposition, or 0 when absent.
+func metricValue(exposition, series string) float64 {
+	for _, line := range strings.Split(exposition, "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+	}
+	return 0
+}
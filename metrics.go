@@ -0,0 +1,72 @@
+package webanalyzer
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds the collectors served on /metrics. It is separate from the
+// default registry so that embedding programs don't get our metrics unasked.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	analysesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webanalyzer_analyses_total",
+		Help: "Analyses run, by outcome (success or error).",
+	}, []string{"outcome"})
+	analysisDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webanalyzer_analysis_duration_seconds",
+		Help:    "Wall time of a whole analysis, fetch and link checks included.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 45, 60},
+	})
+	fetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webanalyzer_fetch_duration_seconds",
+		Help:    "Time to fetch a page, redirects and body included.",
+		Buckets: prometheus.DefBuckets,
+	})
+	linksChecked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webanalyzer_links_checked_total",
+		Help: "Links checked for accessibility.",
+	})
+	linksBroken = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "webanalyzer_links_broken_total",
+		Help: "Checked links found inaccessible.",
+	})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webanalyzer_http_request_duration_seconds",
+		Help:    "Time to serve an HTTP request, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(analysesTotal, analysisDuration, fetchDuration, linksChecked, linksBroken, requestDuration)
+}
+
+// metricsHandler serves the collectors of metricsRegistry in the Prometheus text format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// observeAnalysis records one analysis that started at start and ended with err.
+func observeAnalysis(start time.Time, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "error"
+	}
+	analysesTotal.WithLabelValues(outcome).Inc()
+	analysisDuration.Observe(time.Since(start).Seconds())
+}
+
+// routeLabel maps a request path to one of the server's routes, so that arbitrary paths
+// can't blow up the label set of requestDuration.
+func routeLabel(path string) string {
+	switch path {
+	case "/", "/analyze", "/analyze.json", "/recheck.json", "/api/htmlversion", "/metrics":
+		return path
+	}
+	return "other"
+}
@@ -0,0 +1,30 @@
+package webanalyzer
+
+import "context"
+
+// workerPool bounds the link and image checks running at once across every analysis of a
+// handler or Analyzer (-global-link-workers flag). Each analysis still runs at most its own
+// workers of them.
+type workerPool struct {
+	slots chan struct{}
+}
+
+// newWorkerPool returns a pool running at most n jobs at once (at least one).
+func newWorkerPool(n int) *workerPool {
+	return &workerPool{slots: make(chan struct{}, max(n, 1))}
+}
+
+// submit runs job in a new goroutine once a slot is free, waiting for one if needed. It
+// reports false, without running job, when ctx ends first.
+func (p *workerPool) submit(ctx context.Context, job func()) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+	go func() {
+		defer func() { <-p.slots }()
+		job()
+	}()
+	return true
+}
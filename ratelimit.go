@@ -0,0 +1,132 @@
+package webanalyzer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// newOutboundLimiter returns the token bucket pacing the outbound requests of every analysis
+// (-rate flag), allowing perSecond requests per second, or nil (unlimited) when perSecond is
+// zero or negative.
+func newOutboundLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), max(1, int(perSecond)))
+}
+
+// limitedTransport waits for the outbound limiter before each round trip, so redirects and
+// HEAD/GET retries are paced as well.
+type limitedTransport struct {
+	limiter *rate.Limiter
+	base    http.RoundTripper
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := admitRequest(req.Context(), t.limiter); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// admitRequest charges one outbound request to the budget attached to ctx and waits for
+// limiter, which may be nil. Requests made outside net/http, such as those of the headless
+// browser, go through it too.
+func admitRequest(ctx context.Context, limiter *rate.Limiter) error {
+	if b := budgetFrom(ctx); b != nil && !b.take() {
+		return errRequestBudget
+	}
+	if l := limiter; l != nil {
+		return l.Wait(ctx)
+	}
+	return nil
+}
+
+// busyRetryAfter is the Retry-After sent with a 429 when every analysis slot is taken.
+const busyRetryAfter = 10 * time.Second
+
+// errBusy is reported to requests turned away because every analysis slot is taken.
+var errBusy = errors.New("too many analyses are running right now; please try again in a few seconds")
+
+// limitAnalyses runs next only while one of the -max-analyses slots is free, and otherwise answers 429
+// Too Many Requests at once, so a burst of users can't exhaust sockets and file descriptors
+// here or hammer the sites being checked. The answer matches what next would have sent: the
+// error page, a JSON error or, for format=summary, plain text.
+func (s *server) limitAnalyses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slots := s.cfg.slots
+		if slots == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+			return
+		default:
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(busyRetryAfter.Seconds())))
+		switch {
+		case r.FormValue("format") == "summary":
+			writeText(w, http.StatusTooManyRequests, "Analysis failed: "+errBusy.Error())
+		case strings.HasSuffix(r.URL.Path, ".json") || prefersJSON(r.Header.Get("Accept")):
+			writeJSONErr(w, http.StatusTooManyRequests, errBusy)
+		default:
+			pd := s.errPage(strings.TrimSpace(r.FormValue("u")), 0, errBusy)
+			pd.Busy = true
+			w.Header().Add("Vary", "Accept")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = pageTmpl.Execute(w, pd)
+		}
+	})
+}
+
+// errRequestBudget is returned for requests made after the analysis used up its budget.
+var errRequestBudget = errors.New("outbound request budget exhausted")
+
+// outboundBudget counts the requests left to one analysis.
+type outboundBudget struct {
+	left      atomic.Int64
+	exhausted atomic.Bool
+}
+
+type budgetKey struct{}
+
+// withRequestBudget attaches a budget of n outbound requests to ctx; n <= 0 leaves ctx unlimited.
+func withRequestBudget(ctx context.Context, n int) context.Context {
+	if n <= 0 {
+		return ctx
+	}
+	b := &outboundBudget{}
+	b.left.Store(int64(n))
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// budgetFrom returns the budget attached to ctx, or nil.
+func budgetFrom(ctx context.Context) *outboundBudget {
+	b, _ := ctx.Value(budgetKey{}).(*outboundBudget)
+	return b
+}
+
+// take reserves one request, reporting false once the budget is used up.
+func (b *outboundBudget) take() bool {
+	if b.left.Add(-1) < 0 {
+		b.exhausted.Store(true)
+		return false
+	}
+	return true
+}
+
+// wasExhausted reports whether any request was refused; a nil budget never is.
+func (b *outboundBudget) wasExhausted() bool {
+	return b != nil && b.exhausted.Load()
+}
@@ -0,0 +1,73 @@
+//go:build chromedp
+
+package webanalyzer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chromedp/cdproto/cdp"
+	cdpfetch "github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// renderAvailable reports whether this binary was built with headless rendering support.
+const renderAvailable = true
+
+// renderPage loads u in headless Chrome and returns the DOM serialized after scripts ran.
+// With opts.Screenshot set it also returns a JPEG thumbnail of the first viewport.
+//
+// The browser sends the analysis's User-Agent, and every request it makes, the page and
+// its subresources alike, is paused until admitRequest charges it to the request budget and
+// the outbound rate limit; refused ones fail as blocked. Chrome still connects on its own,
+// though: -ca-bundle, -insecure-tls-hosts, -dns-timeout, -header-timeout and -http1 don't
+// apply to it.
+func renderPage(ctx context.Context, u string, opts analyzeOptions) (html []byte, thumb []byte, err error) {
+	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.UserAgent(opts.userAgent()))
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, allocOpts...)
+	defer cancelAlloc()
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	defer cancelBrowser()
+
+	chromedp.ListenTarget(browserCtx, func(ev any) {
+		paused, ok := ev.(*cdpfetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		// answering blocks on the limiter, so it must not hold up the event loop
+		go func() {
+			c := chromedp.FromContext(browserCtx)
+			execCtx := cdp.WithExecutor(browserCtx, c.Target)
+			if err := admitRequest(ctx, opts.settings().limiter); err != nil {
+				_ = cdpfetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(execCtx)
+				return
+			}
+			_ = cdpfetch.ContinueRequest(paused.RequestID).Do(execCtx)
+		}()
+	})
+
+	var dom string
+	actions := []chromedp.Action{
+		cdpfetch.Enable(),
+		chromedp.EmulateViewport(screenshotWidth, screenshotHeight),
+		chromedp.Navigate(u),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.OuterHTML("html", &dom, chromedp.ByQuery),
+	}
+	if opts.Screenshot {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			thumb, err = page.CaptureScreenshot().
+				WithFormat(page.CaptureScreenshotFormatJpeg).
+				WithQuality(70).
+				WithClip(&page.Viewport{Width: screenshotWidth, Height: screenshotHeight, Scale: screenshotScale}).
+				Do(ctx)
+			return err
+		}))
+	}
+	if err := chromedp.Run(browserCtx, actions...); err != nil {
+		return nil, nil, fmt.Errorf("headless render failed: %w", err)
+	}
+	return []byte(dom), thumb, nil
+}
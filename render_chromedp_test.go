@@ -0,0 +1,90 @@
+//go:build chromedp
+
+package webanalyzer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRenderPage_DetectsScriptInjectedLinks(t *testing.T) {
+	requireBrowser(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><html><body><script>
+			var a = document.createElement("a");
+			a.href = "/injected";
+			a.textContent = "injected";
+			document.body.appendChild(a);
+		</script></body></html>`))
+	}))
+	t.Cleanup(srv.Close)
+	base, _ := normalizeURL(srv.URL)
+
+	html, _, err := renderPage(t.Context(), srv.URL, analyzeOptions{})
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	res, err := analyzeFromHTML(base, string(html))
+	if err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+	if res.InternalLinks != 1 {
+		t.Fatalf("want 1 injected link in rendered mode, got %d", res.InternalLinks)
+	}
+}
+
+func TestRenderPage_Screenshot(t *testing.T) {
+	requireBrowser(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<!doctype html><html><body style="background:#c00"><h1>Shot</h1></body></html>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	_, thumb, err := renderPage(t.Context(), srv.URL, analyzeOptions{Screenshot: true})
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if len(thumb) < 2 || thumb[0] != 0xFF || thumb[1] != 0xD8 {
+		t.Fatalf("want a non-empty JPEG thumbnail, got %d bytes", len(thumb))
+	}
+}
+
+func TestRenderPage_RequestBudgetAndUserAgent(t *testing.T) {
+	requireBrowser(t)
+
+	var hits atomic.Int32
+	agents := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		agents <- r.UserAgent()
+		_, _ = w.Write([]byte(`<!doctype html><html><body><img src="/a.png"><img src="/b.png"></body></html>`))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := withRequestBudget(t.Context(), 1)
+	if _, _, err := renderPage(ctx, srv.URL, analyzeOptions{UserAgent: "render-test/1.0"}); err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if n := hits.Load(); n != 1 || !budgetFrom(ctx).wasExhausted() {
+		t.Fatalf("want the images refused once the budget of 1 is spent, got %d requests", n)
+	}
+	if ua := <-agents; ua != "render-test/1.0" {
+		t.Fatalf("want the configured User-Agent, got %q", ua)
+	}
+}
+
+// requireBrowser skips the test when no headless Chrome/Chromium is installed.
+func requireBrowser(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return
+		}
+	}
+	t.Skip("no headless Chrome/Chromium available")
+}
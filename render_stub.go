@@ -0,0 +1,16 @@
+//go:build !chromedp
+
+package webanalyzer
+
+import (
+	"context"
+	"errors"
+)
+
+// renderAvailable reports whether this binary was built with headless rendering support.
+const renderAvailable = false
+
+// renderPage is unavailable without the chromedp build tag.
+func renderPage(context.Context, string, analyzeOptions) ([]byte, []byte, error) {
+	return nil, nil, errors.New("rendered mode requires a build with -tags chromedp")
+}
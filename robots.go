@@ -0,0 +1,237 @@
+package webanalyzer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRobotsAgent is the product token matched against the User-agent lines of
+// robots.txt when the configured User-Agent doesn't start with one.
+const defaultRobotsAgent = "webanalyzer"
+
+// maxRobotsSize caps the bytes of robots.txt read per host, counted after net/http has
+// decoded any gzip, so a compressed robots.txt can't inflate past it.
+const maxRobotsSize = 512 << 10
+
+// robotsErrorTTL is how long a robots.txt that couldn't be fetched (no response at all)
+// is treated as allowing everything before the next attempt.
+const robotsErrorTTL = time.Minute
+
+// robotsRules are the Allow/Disallow rules of the robots.txt group that applies to our agent.
+type robotsRules []robotsRule
+
+type robotsRule struct {
+	allow   bool
+	length  int // pattern length; the longest matching rule wins
+	pattern *regexp.Regexp
+}
+
+// robotsToken returns the product token of the User-Agent ua, the name before its first
+// "/" or space, lowercased; robots.txt groups are matched against it. A ua that doesn't
+// start with a token gets defaultRobotsAgent.
+func robotsToken(ua string) string {
+	ua = strings.TrimSpace(ua)
+	end := strings.IndexFunc(ua, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == '-')
+	})
+	if end == -1 {
+		end = len(ua)
+	}
+	if end == 0 {
+		return defaultRobotsAgent
+	}
+	return strings.ToLower(ua[:end])
+}
+
+// parseRobots reads a robots.txt and keeps the rules for the product token agent: those of
+// the group whose User-agent equals agent, ignoring case (RFC 9309, section 2.2.1),
+// otherwise those of the "*" group.
+func parseRobots(r io.Reader, agent string) robotsRules {
+	agent = strings.ToLower(agent)
+	groups := make(map[string]robotsRules)
+	var current []string
+	inRules := false
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if inRules {
+				current, inRules = nil, false
+			}
+			current = append(current, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue // "Disallow:" with no path allows everything
+			}
+			rule := robotsRule{allow: key == "allow", length: len(value), pattern: robotsPattern(value)}
+			for _, ua := range current {
+				groups[ua] = append(groups[ua], rule)
+			}
+		}
+	}
+
+	if rules, ok := groups[agent]; ok {
+		return rules
+	}
+	return groups["*"]
+}
+
+// robotsPattern compiles a robots.txt path pattern, where * matches any run of characters
+// and a trailing $ anchors the end.
+func robotsPattern(p string) *regexp.Regexp {
+	anchored := strings.HasSuffix(p, "$")
+	p = strings.TrimSuffix(p, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allows reports whether u may be fetched: the longest matching rule decides, Allow wins
+// ties, and no matching rule allows.
+func (rr robotsRules) allows(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allowed, longest := true, -1
+	for _, r := range rr {
+		if !r.pattern.MatchString(path) {
+			continue
+		}
+		if r.length > longest || (r.length == longest && r.allow) {
+			allowed, longest = r.allow, r.length
+		}
+	}
+	return allowed
+}
+
+// robotsCache holds the robots.txt rules of each host (scheme and authority). One cache
+// serves all analyses of a handler or Analyzer: each robots.txt is fetched at most once per
+// ttl (-robots-ttl), and at most size hosts are kept (-robots-cache-size).
+type robotsCache struct {
+	ttl     time.Duration
+	size    int
+	timeout time.Duration // for each robots.txt fetch (-per-request-timeout)
+	agent   string        // product token of -user-agent, see robotsToken
+
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+// newRobotsCache returns an empty cache with the given limits that keeps the rules for the
+// product token of userAgent.
+func newRobotsCache(ttl time.Duration, size int, timeout time.Duration, userAgent string) *robotsCache {
+	return &robotsCache{ttl: ttl, size: size, timeout: timeout, agent: robotsToken(userAgent), hosts: make(map[string]*robotsEntry)}
+}
+
+type robotsEntry struct {
+	once    sync.Once
+	rules   robotsRules
+	added   time.Time
+	expires time.Time // zero while the first fetch is running; guarded by robotsCache.mu
+}
+
+type robotsKey struct{}
+
+// withRobotsCache attaches c to ctx; link checks under ctx consult it.
+func withRobotsCache(ctx context.Context, c *robotsCache) context.Context {
+	return context.WithValue(ctx, robotsKey{}, c)
+}
+
+// robotsFrom returns the robots.txt cache attached to ctx, or nil when robots.txt is ignored.
+func robotsFrom(ctx context.Context) *robotsCache {
+	c, _ := ctx.Value(robotsKey{}).(*robotsCache)
+	return c
+}
+
+// entry returns the live entry for origin, replacing an expired one. When the cache is full
+// it first makes room with evict.
+func (c *robotsCache) entry(origin string) *robotsEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if e, ok := c.hosts[origin]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e
+	}
+	delete(c.hosts, origin)
+	if len(c.hosts) >= c.size {
+		c.evict(now)
+	}
+	e := &robotsEntry{added: now}
+	c.hosts[origin] = e
+	return e
+}
+
+// evict drops the expired entries and, if that doesn't free a slot, the oldest one. c.mu
+// must be held.
+func (c *robotsCache) evict(now time.Time) {
+	oldest := ""
+	for origin, e := range c.hosts {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(c.hosts, origin)
+		} else if oldest == "" || e.added.Before(c.hosts[oldest].added) {
+			oldest = origin
+		}
+	}
+	if len(c.hosts) >= c.size {
+		delete(c.hosts, oldest)
+	}
+}
+
+// allows reports whether the robots.txt of u's host lets c.agent fetch u, fetching
+// it with client when it isn't cached. A robots.txt that doesn't answer 2xx allows
+// everything; one that can't be fetched at all allows everything for robotsErrorTTL, after
+// which it is tried again.
+//
+// The rules are shared by every analysis, so the fetch doesn't run under the context of
+// the analysis that triggers it: it gets its own c.timeout and no request budget,
+// and that analysis running out of time or budget can't make a host "allow everything"
+// for the others.
+func (c *robotsCache) allows(client *http.Client, u *url.URL) bool {
+	e := c.entry(u.Scheme + "://" + u.Host)
+	e.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		ttl := c.ttl
+		defer func() {
+			c.mu.Lock()
+			e.expires = time.Now().Add(ttl)
+			c.mu.Unlock()
+		}()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.Scheme+"://"+u.Host+"/robots.txt", nil)
+		if err != nil {
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			ttl = min(ttl, robotsErrorTTL)
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			e.rules = parseRobots(io.LimitReader(resp.Body, maxRobotsSize), c.agent)
+		}
+	})
+	return e.rules.allows(u)
+}
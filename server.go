@@ -0,0 +1,303 @@
+package webanalyzer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewHandler validates cfg and returns the handler of the web server. The handler keeps its
+// own copy of the configuration, so several handlers and Analyzers can run side by side.
+func NewHandler(cfg Config) (http.Handler, error) {
+	settings, err := newSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(settings).handler(), nil
+}
+
+// server holds the settings and shared state of one web server; its handlers are methods.
+type server struct {
+	cfg *settings
+}
+
+func newServer(cfg *settings) *server {
+	return &server{cfg: cfg}
+}
+
+// handler returns the routes of s wrapped in the logging and deadline middleware.
+func (s *server) handler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/", s.index)
+	m.Handle("/analyze", s.limitAnalyses(http.HandlerFunc(s.handleAnalyze)))
+	m.Handle("/analyze.json", s.limitAnalyses(http.HandlerFunc(s.handleAnalyzeJSON)))
+	m.Handle("/recheck.json", s.limitAnalyses(http.HandlerFunc(s.handleRecheckJSON)))
+	m.HandleFunc("/api/htmlversion", s.handleHTMLVersion)
+	if s.cfg.Metrics {
+		m.Handle("/metrics", metricsHandler())
+	}
+	return handlerMiddleware(deadlineMiddleware(m, s.cfg.HandlerTimeout))
+}
+
+// handlerMiddleware logs each request with its method, path, status, duration and, for
+// analyses, the submitted URL, and records the duration in requestDuration.
+func handlerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		rl := &requestLog{}
+		defer func() {
+			d := time.Since(start)
+			requestDuration.WithLabelValues(routeLabel(r.URL.Path)).Observe(d.Seconds())
+			attrs := []any{"method", r.Method, "path", r.URL.Path, "status", sr.status, "duration", d}
+			rl.mu.Lock()
+			if rl.url != "" {
+				attrs = append(attrs, "url", rl.url)
+			}
+			rl.mu.Unlock()
+			slog.Info("request", attrs...)
+		}()
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl)))
+	})
+}
+
+// deadlineMiddleware bounds every request with a hard deadline, independent of the analysis
+// budget, so a hanging handler can never hold a connection indefinitely. The handler's output
+// is buffered; if the deadline passes first the client gets 504 Gateway Timeout instead.
+func deadlineMiddleware(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{h: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.h {
+				w.Header()[k] = v
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			_, _ = w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			http.Error(w, "analysis took too long and was aborted", http.StatusGatewayTimeout)
+		}
+	})
+}
+
+// timeoutWriter buffers a response until deadlineMiddleware decides whether to send it.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	h        http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// index serves the main page with the input form.
+func (s *server) index(w http.ResponseWriter, r *http.Request) {
+	_ = pageTmpl.Execute(w, pageData{
+		PerRequestTO:  int(s.cfg.PerRequestTimeout.Seconds()),
+		MaxRedirects:  maxRedirects,
+		Budget:        int(s.cfg.TotalBudget.Seconds()),
+		RenderEnabled: s.cfg.Render,
+	})
+}
+
+// errPage builds the error page data with the given input URL, status, and error message.
+func (s *server) errPage(input string, status int, err error) *pageData {
+	return &pageData{
+		InputURL:      input,
+		HTTPStatus:    status,
+		Error:         err.Error(),
+		PerRequestTO:  int(s.cfg.PerRequestTimeout.Seconds()),
+		MaxRedirects:  maxRedirects,
+		Budget:        int(s.cfg.TotalBudget.Seconds()),
+		RenderEnabled: s.cfg.Render,
+	}
+}
+
+// handleAnalyze processes the URL analysis request. Clients preferring JSON in their
+// Accept header get the /analyze.json response instead of the HTML page.
+func (s *server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if prefersJSON(r.Header.Get("Accept")) {
+		s.handleAnalyzeJSON(w, r)
+		return
+	}
+
+	ctx, span := tracer.Start(r.Context(), "handleAnalyze")
+	defer span.End()
+
+	pgData, _ := s.runAnalysis(ctx, r)
+	_ = pageTmpl.Execute(w, pgData)
+}
+
+// runAnalysis fetches and analyzes the URL submitted in the request form. It always returns
+// the page data to render (with Error set on failure) and the HTTP status that API clients
+// should receive: 400 for bad input, 502 when the page could not be fetched or analyzed.
+func (s *server) runAnalysis(ctx context.Context, r *http.Request) (*pageData, int) {
+	if err := r.ParseForm(); err != nil {
+		return s.errPage("", 0, fmt.Errorf("bad URL form: %w", err)), http.StatusBadRequest
+	}
+
+	raw := strings.TrimSpace(r.Form.Get("u"))
+	if raw == "" {
+		return s.errPage("", 0, errors.New("please provide a URL")), http.StatusBadRequest
+	}
+	logAnalyzedURL(ctx, raw)
+	url, err := normalizeURL(raw)
+	if err != nil {
+		return s.errPage(raw, 0, err), http.StatusBadRequest
+	}
+	opts, err := s.parseAnalyzeOptions(r.Form)
+	if err != nil {
+		return s.errPage(raw, 0, err), http.StatusBadRequest
+	}
+
+	a := &Analyzer{opts: opts, budget: s.cfg.TotalBudget}
+	var res *Result
+	if opts.Pasted {
+		res, err = a.runHTML(ctx, url, []byte(r.Form.Get("html")))
+	} else {
+		res, err = a.run(ctx, url)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "analysis failed", "url", res.URL, "status", res.HTTPStatus, "err", err)
+		return s.errPage(res.URL, res.HTTPStatus, err), http.StatusBadGateway
+	}
+
+	pgData := &pageData{
+		InputURL:      raw,
+		CanonicalURL:  res.URL,
+		HTTPStatus:    res.HTTPStatus,
+		Result:        res.Analysis,
+		Options:       opts,
+		PerRequestTO:  int(s.cfg.PerRequestTimeout.Seconds()),
+		MaxRedirects:  maxRedirects,
+		Budget:        int(s.cfg.TotalBudget.Seconds()),
+		RenderEnabled: s.cfg.Render,
+	}
+	pgData.ID = s.cfg.history.add(pgData)
+	s.notifyWebhook(pgData)
+	return pgData, http.StatusOK
+}
+
+// parseAnalyzeOptions reads the optional analysis toggles from the submitted form. The
+// options default to the server's settings.
+func (s *server) parseAnalyzeOptions(form url.Values) (analyzeOptions, error) {
+	opts := analyzeOptions{
+		CheckImages:  form.Get("images") != "",
+		FetchTitles:  form.Get("titles") != "",
+		Render:       form.Get("render") != "",
+		Screenshot:   form.Get("screenshot") != "",
+		NoCache:      form.Get("nocache") != "",
+		BodyHash:     form.Get("hash") != "",
+		CompareAMP:   form.Get("amp") != "",
+		HeadersOnly:  form.Get("mode") == "headers-only",
+		SkipSelf:     form.Get("skip_self") != "",
+		MetaRefresh:  form.Get("follow_refresh") != "",
+		IgnoreRobots: s.cfg.IgnoreRobots,
+		Pasted:       strings.TrimSpace(form.Get("html")) != "",
+		cfg:          s.cfg,
+	}
+	opts.AnalyzeErrors = s.cfg.AnalyzeErrors || form.Get("analyze_errors") != ""
+	opts.Quick = form.Get("quick") != ""
+	if v := strings.TrimSpace(form.Get("max_redirects")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRedirects {
+			return opts, fmt.Errorf("invalid redirect limit %q: want 1 to %d", v, maxRedirects)
+		}
+		opts.MaxRedirects = n
+	}
+	// a screenshot needs the headless browser anyway
+	opts.Render = opts.Render || opts.Screenshot
+	if opts.HeadersOnly && opts.Render {
+		return opts, errors.New("headers-only mode can't be combined with rendering")
+	}
+	if opts.Quick && (opts.HeadersOnly || opts.Render || opts.Pasted) {
+		return opts, errors.New("quick mode can't be combined with headers-only, rendered or pasted HTML mode")
+	}
+	if opts.Render && !s.cfg.Render {
+		return opts, errors.New("rendered mode is not enabled on this server")
+	}
+	if opts.Pasted {
+		if opts.HeadersOnly || opts.Render {
+			return opts, errors.New("pasted HTML can't be combined with headers-only or rendered mode")
+		}
+		if n := len(form.Get("html")); int64(n) > opts.bodyLimit() {
+			return opts, fmt.Errorf("pasted HTML is %d bytes, over the %d-byte limit", n, opts.bodyLimit())
+		}
+		// links relative to a made-up base URL usually go nowhere; check them only on request
+		opts.SkipLinkChecks = form.Get("check_links") == ""
+	}
+	if v := strings.TrimSpace(form.Get("link_timeout")); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return opts, fmt.Errorf("invalid link timeout %q: want a positive number of seconds", v)
+		}
+		opts.LinkTimeout = time.Duration(secs) * time.Second
+	}
+	if v := strings.TrimSpace(form.Get("link_retries")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxLinkRetries {
+			return opts, fmt.Errorf("invalid link retries %q: want 0 to %d", v, maxLinkRetries)
+		}
+		opts.LinkRetries = n
+		if n == 0 {
+			opts.LinkRetries = -1
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,34 @@
+package webanalyzer
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jestress/webanalyzer"
+
+// tracer creates all spans. It stays a no-op until SetupTracing installs a provider.
+var tracer trace.Tracer = otel.Tracer(tracerName)
+
+// SetupTracing exports spans over OTLP/HTTP to the given endpoint URL (e.g. http://localhost:4318).
+// The returned function flushes pending spans and shuts the provider down.
+func SetupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "webanalyzer"))),
+	)
+	otel.SetTracerProvider(tp)
+	tracer = tp.Tracer(tracerName)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,209 @@
+package webanalyzer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupIPAddr resolves host names for outbound connections; tests swap it.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// newTransport builds the round tripper for outbound requests under the settings s: sending
+// ua as User-Agent, paced by the -rate limiter, resolving names within -dns-timeout through
+// the DNS cache, dialing
+// with dial, waiting headerTimeout for headers (a slow server's time to first byte; reading
+// the body is not covered), trusting -ca-bundle, skipping certificate verification only for
+// -insecure-tls-hosts (e.g. internal sites with self-signed certificates), and negotiating
+// HTTP/2 unless -http1.
+func newTransport(s *settings, dial func(ctx context.Context, network, addr string) (net.Conn, error), maxIdle int, tlsTimeout, headerTimeout time.Duration, ua string) http.RoundTripper {
+	build := func(skipVerify bool) *http.Transport {
+		t := &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			MaxIdleConns:          maxIdle,
+			IdleConnTimeout:       30 * time.Second,
+			DisableCompression:    false,
+			DialContext:           resolveThenDial(dial, s.DNSTimeout, s.dns),
+			TLSHandshakeTimeout:   tlsTimeout,
+			ResponseHeaderTimeout: headerTimeout,
+			TLSClientConfig:       &tls.Config{RootCAs: s.rootCAs, InsecureSkipVerify: skipVerify},
+			// the custom dialer and TLS config would otherwise turn HTTP/2 off
+			ForceAttemptHTTP2: !s.HTTP1,
+		}
+		if s.HTTP1 {
+			// a non-nil, empty map is what keeps net/http from upgrading to HTTP/2
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		return t
+	}
+	if len(s.insecureTLSHosts) == 0 {
+		return userAgentTransport{ua: ua, base: limitedTransport{limiter: s.limiter, base: build(false)}}
+	}
+	tlsSwitch := hostTLSSwitch{insecure: s.insecureTLSHosts, verified: build(false), unverified: build(true)}
+	return userAgentTransport{ua: ua, base: limitedTransport{limiter: s.limiter, base: tlsSwitch}}
+}
+
+// userAgentTransport sets the User-Agent of requests that don't carry one, including
+// redirect hops.
+type userAgentTransport struct {
+	ua   string
+	base http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.ua)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// loadCABundle returns the system certificate pool extended with the PEM certificates in
+// path, so sites signed by a private CA verify without losing the public ones.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// hostTLSSwitch sends requests for the insecure hosts through a transport that skips
+// certificate verification and everything else through a verifying one. Each redirect hop is
+// routed by its own host.
+type hostTLSSwitch struct {
+	insecure             []string
+	verified, unverified http.RoundTripper
+}
+
+func (s hostTLSSwitch) RoundTrip(req *http.Request) (*http.Response, error) {
+	if tlsVerifySkipped(req.URL.Hostname(), s.insecure) {
+		return s.unverified.RoundTrip(req)
+	}
+	return s.verified.RoundTrip(req)
+}
+
+// tlsVerifySkipped reports whether host is one of the insecure hosts.
+func tlsVerifySkipped(host string, insecure []string) bool {
+	return slices.ContainsFunc(insecure, func(h string) bool { return strings.EqualFold(h, host) })
+}
+
+// resolveThenDial resolves the host of addr within dnsTimeout and then dials its addresses
+// in order with dial, so a slow resolver can't eat the connect timeout. Addresses found in
+// cache, which may be nil, are dialed without a lookup; fresh ones are added to it.
+func resolveThenDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), dnsTimeout time.Duration, cache *dnsCache) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		ips, ok := cache.get(host)
+		if !ok {
+			lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+			ips, err = lookupIPAddr(lookupCtx, host)
+			cancel()
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+					return nil, fmt.Errorf("DNS lookup for %s timed out after %s", host, dnsTimeout)
+				}
+				return nil, fmt.Errorf("DNS lookup for %s failed: %w", host, err)
+			}
+			cache.put(host, ips)
+		}
+		var dialErr error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			dialErr = err
+		}
+		if dialErr == nil {
+			dialErr = fmt.Errorf("DNS lookup for %s returned no addresses", host)
+		}
+		return nil, dialErr
+	}
+}
+
+// dnsCache holds the addresses each host name resolved to, so the link checks of a page,
+// which mostly share a handful of hosts, don't each wait for the resolver. One cache serves
+// all analyses of a handler or Analyzer: an answer is reused for ttl (-dns-cache-ttl), at
+// most size hosts are kept (-dns-cache-size), and failed lookups are not cached. Concurrent
+// misses for the same host may each look it up.
+type dnsCache struct {
+	ttl  time.Duration
+	size int
+
+	mu    sync.Mutex
+	hosts map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []net.IPAddr
+	added   time.Time
+	expires time.Time
+}
+
+// newDNSCache returns an empty cache with the given limits, or nil when ttl is zero.
+func newDNSCache(ttl time.Duration, size int) *dnsCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &dnsCache{ttl: ttl, size: size, hosts: make(map[string]dnsEntry)}
+}
+
+// get returns the live addresses of host; a nil cache has none.
+func (c *dnsCache) get(host string) ([]net.IPAddr, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.hosts[strings.ToLower(host)]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil, false
+	}
+	return e.ips, true
+}
+
+// put stores the addresses of host, first dropping the expired entries and, if that doesn't
+// free a slot, the oldest one when the cache is full. A nil cache ignores it.
+func (c *dnsCache) put(host string, ips []net.IPAddr) {
+	if c == nil || len(ips) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	host = strings.ToLower(host)
+	if _, ok := c.hosts[host]; !ok && len(c.hosts) >= c.size {
+		oldest := ""
+		for h, e := range c.hosts {
+			if !now.Before(e.expires) {
+				delete(c.hosts, h)
+			} else if oldest == "" || e.added.Before(c.hosts[oldest].added) {
+				oldest = h
+			}
+		}
+		if len(c.hosts) >= c.size {
+			delete(c.hosts, oldest)
+		}
+	}
+	c.hosts[host] = dnsEntry{ips: ips, added: now, expires: now.Add(c.ttl)}
+}
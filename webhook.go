@@ -0,0 +1,85 @@
+package webanalyzer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WebhookSignatureHeader carries "sha256=<hex HMAC of the body>" when a secret is configured.
+const WebhookSignatureHeader = "X-Webanalyzer-Signature"
+
+// notifyWebhook posts the result to the -webhook URL, if any, in the background, signed
+// with -webhook-secret when set. Delivery failures are logged and otherwise ignored so they
+// never delay or fail the analysis itself.
+func (s *server) notifyWebhook(pd *pageData) {
+	if s.cfg.WebhookURL == "" || pd.Result == nil {
+		return
+	}
+	body, err := json.Marshal(analysisResponse{
+		ID:           pd.ID,
+		CanonicalURL: pd.CanonicalURL,
+		HTTPStatus:   pd.HTTPStatus,
+		Analysis:     pd.Result,
+	})
+	if err != nil {
+		slog.Error("webhook payload", "err", err)
+		return
+	}
+	go func() {
+		if err := sendWebhook(context.Background(), s.cfg.WebhookURL, s.cfg.WebhookSecret, s.cfg.UserAgent, body); err != nil {
+			slog.Error("webhook delivery failed", "url", pd.CanonicalURL, "err", err)
+		}
+	}()
+}
+
+// sendWebhook POSTs body to endpoint with User-Agent ua, retrying failed deliveries
+// (network errors or non-2xx answers) up to webhookAttempts times with a growing pause in
+// between.
+func sendWebhook(ctx context.Context, endpoint, secret, ua string, body []byte) error {
+	client := &http.Client{Timeout: webhookTimeout}
+	var lastErr error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(time.Duration(attempt-1) * webhookBackoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", ua)
+		if secret != "" {
+			req.Header.Set(WebhookSignatureHeader, "sha256="+signPayload(secret, body))
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s answered %s", endpoint, resp.Status)
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", webhookAttempts, lastErr)
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}